@@ -0,0 +1,32 @@
+// Command knowledge-index rebuilds the index of the knowledge base the expert answers from.
+// Run it whenever the documents change and restart the bot to load the new index:
+//
+//	knowledge-index -dir ./knowledge -out ./knowledge.json
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/knowledge"
+)
+
+func main() {
+	dir := flag.String("dir", "knowledge", "directory of the markdown and text documents")
+	out := flag.String("out", "knowledge.json", "file to write the index to")
+	flag.Parse()
+
+	idx, err := knowledge.BuildDir(*dir)
+	if err != nil {
+		log.Fatalf("failed to build knowledge index: %s", err)
+	}
+	if len(idx.Passages) == 0 {
+		log.Fatalf("no documents found in %s", *dir)
+	}
+
+	err = idx.Save(*out)
+	if err != nil {
+		log.Fatalf("failed to save knowledge index: %s", err)
+	}
+	log.Printf("indexed %d passages from %s into %s", len(idx.Passages), *dir, *out)
+}
@@ -1,21 +1,56 @@
 package main
 
 import (
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 const secret = "********"
 
 type config struct {
-	dataspikeUrl    string
-	DataspikeToken  SecretString
-	httpPort        int
-	prompt          string
-	TelegramToken   SecretString
-	telegramOffset  int
-	telegramTimeout int
-	webhookPath     string
-	webhookUrl      string
+	dataspikeUrl   string
+	DataspikeToken SecretString
+	// expertBlockedTopics and expertAllowedDomains are the comma separated output policy of the expert:
+	// answers mentioning the topics aren't sent, links to other domains are removed.
+	expertBlockedTopics  []string
+	expertAllowedDomains []string
+	// expertMemory is how long the conversation with the LLM is kept after the last question, zero forgets it.
+	expertMemory time.Duration
+	// expertTimeout ends expert mode after the user has been silent for it.
+	expertTimeout time.Duration
+	// gptUrl, gptModel and GPTToken configure the OpenAI compatible LLM answering text messages.
+	// The LLM is disabled when neither the URL nor the token is set.
+	gptUrl   string
+	gptModel string
+	GPTToken SecretString
+	// gptTimeout limits a completion, the following updates of the user wait for it.
+	gptTimeout time.Duration
+	httpPort   int
+	// knowledgeIndex is the file built by the knowledge-index command, the expert answers
+	// from the general knowledge of the model when it is empty.
+	knowledgeIndex    string
+	knowledgePassages int
+	// metricsAddr is the internal address /debug/vars is served on, the metrics are disabled when it is empty.
+	metricsAddr string
+	// menuStorePath is the file remembering the published command menus, so the stale ones are deleted.
+	menuStorePath string
+	// messageRetention is how long messages are kept in the chat, zero keeps them.
+	messageRetention time.Duration
+	// messageStorePath is the file keeping the messages to delete across restarts, in memory when empty.
+	messageStorePath string
+	prompt           string
+	// reminderIdle and reminderBeforeExpiry are the delays of the reminders, zero disables the reminder.
+	reminderIdle         time.Duration
+	reminderBeforeExpiry time.Duration
+	// reminderStorePath is the file keeping scheduled reminders across restarts, in memory when empty.
+	reminderStorePath string
+	TelegramToken     SecretString
+	telegramOffset    int
+	telegramTimeout   int
+	webhookPath       string
+	webhookUrl        string
 }
 
 func newConfig() config {
@@ -23,23 +58,67 @@ func newConfig() config {
 	viper.SetDefault("TG_OFFSET", 0)
 	viper.SetDefault("TG_TIMEOUT", 60)
 	viper.SetDefault("DEBUG_MODE", true)
+	viper.SetDefault("EXPERT_ALLOWED_DOMAINS", "dataspike.io")
+	viper.SetDefault("EXPERT_MEMORY_TTL", 30*time.Minute)
+	viper.SetDefault("EXPERT_TIMEOUT", 10*time.Minute)
+	viper.SetDefault("GPT_TIMEOUT", 30*time.Second)
+	viper.SetDefault("KNOWLEDGE_PASSAGES", 3)
+	viper.SetDefault("MESSAGE_RETENTION", time.Hour)
+	viper.SetDefault("METRICS_ADDR", "127.0.0.1:9090")
+	viper.SetDefault("REMINDER_IDLE", 30*time.Minute)
+	viper.SetDefault("REMINDER_BEFORE_EXPIRY", time.Hour)
 
 	// read config
 	cfg := config{
-		dataspikeUrl:    viper.GetString("DS_URL"),
-		DataspikeToken:  NewSecretString(viper.GetString("DS_TOKEN")),
-		httpPort:        viper.GetInt("HTTP_PORT"),
-		prompt:          viper.GetString("PROMPT"),
-		TelegramToken:   NewSecretString(viper.GetString("TG_TOKEN")),
-		telegramOffset:  viper.GetInt("TG_OFFSET"),
-		telegramTimeout: viper.GetInt("TG_TIMEOUT"),
-		webhookPath:     viper.GetString("WEBHOOK_PATH"),
-		webhookUrl:      viper.GetString("WEBHOOK_URL"),
+		dataspikeUrl:         viper.GetString("DS_URL"),
+		DataspikeToken:       NewSecretString(viper.GetString("DS_TOKEN")),
+		expertBlockedTopics:  list(viper.GetString("EXPERT_BLOCKED_TOPICS")),
+		expertAllowedDomains: list(viper.GetString("EXPERT_ALLOWED_DOMAINS")),
+		expertMemory:         viper.GetDuration("EXPERT_MEMORY_TTL"),
+		expertTimeout:        viper.GetDuration("EXPERT_TIMEOUT"),
+		gptUrl:               viper.GetString("GPT_URL"),
+		gptModel:             viper.GetString("GPT_MODEL"),
+		GPTToken:             NewSecretString(viper.GetString("GPT_TOKEN")),
+		gptTimeout:           viper.GetDuration("GPT_TIMEOUT"),
+		httpPort:             viper.GetInt("HTTP_PORT"),
+		knowledgeIndex:       viper.GetString("KNOWLEDGE_INDEX"),
+		knowledgePassages:    viper.GetInt("KNOWLEDGE_PASSAGES"),
+		metricsAddr:          viper.GetString("METRICS_ADDR"),
+		menuStorePath:        viper.GetString("MENU_STORE_PATH"),
+		messageRetention:     viper.GetDuration("MESSAGE_RETENTION"),
+		messageStorePath:     viper.GetString("MESSAGE_STORE_PATH"),
+		prompt:               viper.GetString("PROMPT"),
+		reminderIdle:         viper.GetDuration("REMINDER_IDLE"),
+		reminderBeforeExpiry: viper.GetDuration("REMINDER_BEFORE_EXPIRY"),
+		reminderStorePath:    viper.GetString("REMINDER_STORE_PATH"),
+		TelegramToken:        NewSecretString(viper.GetString("TG_TOKEN")),
+		telegramOffset:       viper.GetInt("TG_OFFSET"),
+		telegramTimeout:      viper.GetInt("TG_TIMEOUT"),
+		webhookPath:          viper.GetString("WEBHOOK_PATH"),
+		webhookUrl:           viper.GetString("WEBHOOK_URL"),
 	}
 
 	return cfg
 }
 
+// list splits the comma separated value, the items may contain spaces.
+func list(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// secrets are the raw values of the secrets in the config, which must never reach the logs.
+func (c config) secrets() []string {
+	return []string{c.DataspikeToken.RawString(), c.GPTToken.RawString(), c.TelegramToken.RawString()}
+}
+
 type SecretString struct {
 	secret string
 }
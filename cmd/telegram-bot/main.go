@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"github.com/dataspike-io/docver-sdk-go"
 	"github.com/dataspike-io/docver-tg-bot/internal/cache"
 	"github.com/dataspike-io/docver-tg-bot/internal/handlers"
+	"github.com/dataspike-io/docver-tg-bot/pkg/conversation"
+	"github.com/dataspike-io/docver-tg-bot/pkg/knowledge"
+	"github.com/dataspike-io/docver-tg-bot/pkg/redact"
+	"github.com/dataspike-io/docver-tg-bot/pkg/reminder"
+	"github.com/dataspike-io/docver-tg-bot/pkg/retention"
 	"github.com/dataspike-io/docver-tg-bot/pkg/telegram_bot"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -23,6 +30,9 @@ func main() {
 	viper.AutomaticEnv()
 
 	cfg := newConfig()
+	// the log package is also the output of the default slog logger
+	log.SetOutput(redact.New(cfg.secrets()...).Writer(os.Stderr))
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	memoryCache, err := cache.NewMemoryCache(0)
@@ -38,7 +48,56 @@ func main() {
 		log.Fatalf("failed to create BotAPI: %s", err)
 	}
 
-	dsBot, err := telegram_bot.NewTelegramBot(bot, dataspikeClient, memoryCache)
+	var reminders reminder.Store = reminder.NewMemoryStore()
+	if cfg.reminderStorePath != "" {
+		reminders, err = reminder.NewFileStore(cfg.reminderStorePath)
+		if err != nil {
+			log.Fatalf("failed to open reminder store: %s", err)
+		}
+	}
+
+	options := []telegram_bot.Option{
+		telegram_bot.WithLogger(slog.Default()),
+		telegram_bot.WithSecrets(cfg.secrets()...),
+		telegram_bot.WithReminders(reminders, cfg.reminderIdle, cfg.reminderBeforeExpiry),
+	}
+	if cfg.gptUrl != "" || cfg.GPTToken.RawString() != "" {
+		llm := telegram_bot.NewOpenAI(&http.Client{Timeout: cfg.gptTimeout}, cfg.gptUrl, cfg.gptModel, cfg.GPTToken.RawString())
+		options = append(options, telegram_bot.WithGPT(llm, cfg.prompt), telegram_bot.WithExpertTimeout(cfg.expertTimeout))
+		options = append(options, telegram_bot.WithOutputPolicy(telegram_bot.OutputPolicy{
+			BlockedTopics:  cfg.expertBlockedTopics,
+			AllowedDomains: cfg.expertAllowedDomains,
+		}))
+		if cfg.knowledgeIndex != "" {
+			kb, err := knowledge.Load(cfg.knowledgeIndex)
+			if err != nil {
+				log.Fatalf("failed to load knowledge index: %s", err)
+			}
+			options = append(options, telegram_bot.WithKnowledgeBase(kb, cfg.knowledgePassages))
+		}
+		if cfg.expertMemory > 0 {
+			conversations, err := conversation.NewMemoryStore(0)
+			if err != nil {
+				log.Fatalf("failed to create conversation store: %s", err)
+			}
+			options = append(options, telegram_bot.WithExpertMemory(conversations, conversation.WithTTL(cfg.expertMemory)))
+		}
+	}
+	if cfg.menuStorePath != "" {
+		options = append(options, telegram_bot.WithMenuStore(telegram_bot.NewMenuFileStore(cfg.menuStorePath)))
+	}
+	if cfg.messageRetention > 0 {
+		var messages retention.Store = retention.NewMemoryStore()
+		if cfg.messageStorePath != "" {
+			messages, err = retention.NewFileStore(cfg.messageStorePath)
+			if err != nil {
+				log.Fatalf("failed to open message store: %s", err)
+			}
+		}
+		options = append(options, telegram_bot.WithMessageRetention(messages, cfg.messageRetention))
+	}
+
+	dsBot, err := telegram_bot.NewTelegramBot(bot, dataspikeClient, memoryCache, options...)
 	if err != nil {
 		log.Fatalf("failed to create telegram dsBot: %s", err)
 	}
@@ -48,6 +107,18 @@ func main() {
 	mux := http.NewServeMux()
 	mux.Handle(cfg.webhookPath, handler)
 
+	if cfg.metricsAddr != "" {
+		// metrics, e.g. the personal data redacted from the questions to the LLM, are kept off the public port
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			err := http.ListenAndServe(cfg.metricsAddr, metrics)
+			if err != nil {
+				log.Printf("failed to serve metrics: %s", err)
+			}
+		}()
+	}
+
 	err = http.ListenAndServe(fmt.Sprintf(":%d", cfg.httpPort), mux)
 	if err != nil {
 		log.Fatalf("failed to listen server: %s", err)
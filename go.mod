@@ -4,13 +4,13 @@ go 1.21
 
 require (
 	github.com/Yiling-J/theine-go v0.3.1
-	github.com/ayush6624/go-chatgpt v0.3.0
 	github.com/dataspike-io/docver-sdk-go v0.0.3
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/gofrs/uuid v4.4.0+incompatible
 	github.com/golang/mock v1.4.4
 	github.com/spf13/viper v1.17.0
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/image v0.14.0
 )
 
 require (
@@ -36,7 +36,7 @@ require (
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
 	golang.org/x/sys v0.13.0 // indirect
-	golang.org/x/text v0.13.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
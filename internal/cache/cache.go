@@ -4,16 +4,16 @@ import (
 	"context"
 	"errors"
 	"github.com/Yiling-J/theine-go"
-	dataspike "github.com/dataspike-io/docver-sdk-go"
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
 )
 
 const size = 1000
 
 type MemoryCache struct {
-	client *theine.Cache[string, *dataspike.Verification]
+	client *theine.Cache[string, *flow.Session]
 }
 
-func (m *MemoryCache) GetVerification(ctx context.Context, tgId string) (*dataspike.Verification, error) {
+func (m *MemoryCache) GetSession(ctx context.Context, tgId string) (*flow.Session, error) {
 	if value, ok := m.client.Get(tgId); !ok {
 		return nil, errors.New("verification not found")
 	} else {
@@ -21,15 +21,15 @@ func (m *MemoryCache) GetVerification(ctx context.Context, tgId string) (*datasp
 	}
 }
 
-func (m *MemoryCache) SetVerification(ctx context.Context, tgId string, v *dataspike.Verification) error {
-	if !m.client.Set(tgId, v, 0) {
+func (m *MemoryCache) SetSession(ctx context.Context, tgId string, s *flow.Session) error {
+	if !m.client.Set(tgId, s, 0) {
 		return errors.New("set error")
 	}
 
 	return nil
 }
 
-func (m *MemoryCache) RemoveVerification(ctx context.Context, tgId string) error {
+func (m *MemoryCache) RemoveSession(ctx context.Context, tgId string) error {
 	m.client.Delete(tgId)
 	return nil
 }
@@ -38,7 +38,7 @@ func NewMemoryCache(maxSize int64) (*MemoryCache, error) {
 	if maxSize <= 0 {
 		maxSize = size
 	}
-	client, err := theine.NewBuilder[string, *dataspike.Verification](maxSize).Build()
+	client, err := theine.NewBuilder[string, *flow.Session](maxSize).Build()
 	if err != nil {
 		return nil, err
 	}
@@ -6,13 +6,14 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/dataspike-io/docver-sdk-go"
 	"github.com/dataspike-io/docver-tg-bot/internal/models"
 )
 
 type (
 	bot interface {
 		SendVerificationStatus(context.Context, string, string) error
-		CheckLiveness(context.Context, string, string) error
+		CheckLiveness(context.Context, string, string, dataspike.Errors) error
 	}
 
 	TgBotHandler struct {
@@ -61,7 +62,7 @@ func (t *TgBotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if docverCheck.Step == "liveness" {
-			if err = t.bot.CheckLiveness(r.Context(), docverCheck.ApplicantId, docverCheck.Result.Status); err != nil {
+			if err = t.bot.CheckLiveness(r.Context(), docverCheck.ApplicantId, docverCheck.Result.Status, docverCheck.Result.Errors); err != nil {
 				// TODO: logging
 			}
 		}
@@ -1,6 +1,10 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/dataspike-io/docver-sdk-go"
+)
 
 type WebhookEvent struct {
 	Id        string          `json:"id"`
@@ -20,10 +24,7 @@ type DocverCheck struct {
 	ApplicantId    string `json:"applicant_id"`
 	Step           string `json:"step"`
 	Result         struct {
-		Status string `json:"status"`
-		Errors []struct {
-			Code    int    `json:"code"`
-			Message string `json:"message"`
-		} `json:"errors"`
+		Status string           `json:"status"`
+		Errors dataspike.Errors `json:"errors"`
 	} `json:"result"`
 }
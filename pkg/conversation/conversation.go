@@ -0,0 +1,196 @@
+// Package conversation keeps the recent messages of users talking to the LLM, so follow-up
+// questions are answered in context.
+package conversation
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Yiling-J/theine-go"
+)
+
+const (
+	defaultTTL       = 30 * time.Minute
+	defaultMaxTurns  = 20
+	defaultMaxTokens = 2000
+	defaultStoreSize = 1000
+	// turnOverhead is the tokens the API spends on the role and the separators of every message.
+	turnOverhead = 4
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Turn is a message of the conversation.
+type Turn struct {
+	Role    Role      `json:"role"`
+	Content string    `json:"content"`
+	At      time.Time `json:"at"`
+}
+
+// Store keeps the conversations. Implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns the turns of the user, none when there is no conversation.
+	Load(ctx context.Context, userID string) ([]Turn, error)
+	// Save replaces the turns of the user, the conversation may be dropped once the ttl has passed.
+	Save(ctx context.Context, userID string, turns []Turn, ttl time.Duration) error
+	Delete(ctx context.Context, userID string) error
+}
+
+type Option func(m *Memory)
+
+// WithTTL is a Option that allows you set how long the conversation is kept after its last message.
+// Default value is 30 minutes.
+func WithTTL(ttl time.Duration) Option {
+	return func(m *Memory) {
+		if ttl > 0 {
+			m.ttl = ttl
+		}
+	}
+}
+
+// WithMaxTurns is a Option that allows you limit the number of messages kept per user.
+// Default value is 20.
+func WithMaxTurns(turns int) Option {
+	return func(m *Memory) {
+		if turns > 0 {
+			m.maxTurns = turns
+		}
+	}
+}
+
+// WithMaxTokens is a Option that allows you limit the estimated tokens of the messages kept per user,
+// so the history fits the context window of the model along with the prompt. Default value is 2000.
+func WithMaxTokens(tokens int) Option {
+	return func(m *Memory) {
+		if tokens > 0 {
+			m.maxTokens = tokens
+		}
+	}
+}
+
+// Memory keeps a bounded history of every user: the oldest messages are dropped once the history
+// is over the limits and the whole history is forgotten once the user has been silent for the ttl.
+type Memory struct {
+	store     Store
+	ttl       time.Duration
+	maxTurns  int
+	maxTokens int
+	now       func() time.Time
+}
+
+func NewMemory(store Store, options ...Option) *Memory {
+	m := &Memory{
+		store:     store,
+		ttl:       defaultTTL,
+		maxTurns:  defaultMaxTurns,
+		maxTokens: defaultMaxTokens,
+		now:       time.Now,
+	}
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// History returns the conversation of the user, oldest message first.
+func (m *Memory) History(ctx context.Context, userID string) ([]Turn, error) {
+	turns, err := m.store.Load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(turns) == 0 || m.now().Sub(turns[len(turns)-1].At) > m.ttl {
+		return nil, nil
+	}
+
+	return turns, nil
+}
+
+// Remember appends the turns, e.g. the question and the answer, to the conversation of the user.
+func (m *Memory) Remember(ctx context.Context, userID string, turns ...Turn) error {
+	history, err := m.History(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	now := m.now()
+	for _, turn := range turns {
+		if turn.At.IsZero() {
+			turn.At = now
+		}
+		history = append(history, turn)
+	}
+
+	return m.store.Save(ctx, userID, m.truncate(history), m.ttl)
+}
+
+// Reset forgets the conversation of the user.
+func (m *Memory) Reset(ctx context.Context, userID string) error {
+	return m.store.Delete(ctx, userID)
+}
+
+// truncate drops the oldest turns until the history fits the limits. The history always starts with
+// a question, an answer without it would confuse the model.
+func (m *Memory) truncate(turns []Turn) []Turn {
+	tokens := 0
+	for _, turn := range turns {
+		tokens += Tokens(turn.Content)
+	}
+
+	start := 0
+	for start < len(turns) && (len(turns)-start > m.maxTurns || tokens > m.maxTokens || turns[start].Role != RoleUser) {
+		tokens -= Tokens(turns[start].Content)
+		start++
+	}
+
+	return append([]Turn(nil), turns[start:]...)
+}
+
+// Tokens estimates the tokens the text takes in the model context, about four characters per token
+// for English text plus the overhead of the message.
+func Tokens(text string) int {
+	return (utf8.RuneCountInString(text)+3)/4 + turnOverhead
+}
+
+// MemoryStore keeps the conversations of the most active users in memory, they are lost on restart.
+type MemoryStore struct {
+	client *theine.Cache[string, []Turn]
+}
+
+// NewMemoryStore creates a store of the conversations of up to size users, 1000 when size isn't positive.
+func NewMemoryStore(size int64) (*MemoryStore, error) {
+	if size <= 0 {
+		size = defaultStoreSize
+	}
+	client, err := theine.NewBuilder[string, []Turn](size).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryStore{client: client}, nil
+}
+
+func (s *MemoryStore) Load(_ context.Context, userID string) ([]Turn, error) {
+	turns, _ := s.client.Get(userID)
+	return append([]Turn(nil), turns...), nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, userID string, turns []Turn, ttl time.Duration) error {
+	if !s.client.SetWithTTL(userID, append([]Turn(nil), turns...), 1, ttl) {
+		return errors.New("set error")
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, userID string) error {
+	s.client.Delete(userID)
+	return nil
+}
@@ -0,0 +1,141 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemory(t *testing.T, now *time.Time, options ...Option) *Memory {
+	store, err := NewMemoryStore(0)
+	assert.NoError(t, err)
+	m := NewMemory(store, options...)
+	m.now = func() time.Time { return *now }
+
+	return m
+}
+
+func TestMemory_Remember(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+	m := newMemory(t, &now, WithMaxTurns(4))
+
+	assert.NoError(t, m.Remember(ctx, "1", Turn{Role: RoleUser, Content: "What is KYC?"}, Turn{Role: RoleAssistant, Content: "Know your customer."}))
+	now = now.Add(time.Minute)
+	assert.NoError(t, m.Remember(ctx, "1", Turn{Role: RoleUser, Content: "And what about Germany?"}, Turn{Role: RoleAssistant, Content: "The same."}))
+	assert.NoError(t, m.Remember(ctx, "2", Turn{Role: RoleUser, Content: "Hi"}, Turn{Role: RoleAssistant, Content: "Hello"}))
+
+	history, err := m.History(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []Turn{
+		{Role: RoleUser, Content: "What is KYC?", At: now.Add(-time.Minute)},
+		{Role: RoleAssistant, Content: "Know your customer.", At: now.Add(-time.Minute)},
+		{Role: RoleUser, Content: "And what about Germany?", At: now},
+		{Role: RoleAssistant, Content: "The same.", At: now},
+	}, history)
+
+	// the oldest question and its answer make room for the new ones
+	assert.NoError(t, m.Remember(ctx, "1", Turn{Role: RoleUser, Content: "And France?"}, Turn{Role: RoleAssistant, Content: "Also."}))
+	history, err = m.History(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"And what about Germany?", "The same.", "And France?", "Also."}, contents(history))
+
+	assert.NoError(t, m.Reset(ctx, "1"))
+	history, err = m.History(ctx, "1")
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+
+	history, err = m.History(ctx, "2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hi", "Hello"}, contents(history))
+}
+
+func TestMemory_History_ttl(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+	m := newMemory(t, &now, WithTTL(time.Hour))
+
+	assert.NoError(t, m.Remember(ctx, "1", Turn{Role: RoleUser, Content: "What is KYC?"}, Turn{Role: RoleAssistant, Content: "Know your customer."}))
+	now = now.Add(time.Hour)
+	history, err := m.History(ctx, "1")
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	now = now.Add(time.Second)
+	history, err = m.History(ctx, "1")
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+
+	assert.NoError(t, m.Remember(ctx, "1", Turn{Role: RoleUser, Content: "Hi"}, Turn{Role: RoleAssistant, Content: "Hello"}))
+	history, err = m.History(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hi", "Hello"}, contents(history))
+}
+
+func TestMemory_truncate(t *testing.T) {
+	t.Parallel()
+	long := strings.Repeat("word ", 80)
+	m := NewMemory(nil, WithMaxTurns(10), WithMaxTokens(3*Tokens(long)))
+
+	tests := []struct {
+		name  string
+		turns []Turn
+		want  []string
+	}{
+		{
+			name:  "fits",
+			turns: []Turn{{Role: RoleUser, Content: "q1"}, {Role: RoleAssistant, Content: "a1"}},
+			want:  []string{"q1", "a1"},
+		},
+		{
+			name: "too many tokens",
+			turns: []Turn{
+				{Role: RoleUser, Content: long}, {Role: RoleAssistant, Content: long},
+				{Role: RoleUser, Content: "q2"}, {Role: RoleAssistant, Content: long},
+				{Role: RoleUser, Content: "q3"}, {Role: RoleAssistant, Content: "a3"},
+			},
+			want: []string{"q2", long, "q3", "a3"},
+		},
+		{
+			name: "starts with an answer",
+			turns: []Turn{
+				{Role: RoleUser, Content: long}, {Role: RoleAssistant, Content: long},
+				{Role: RoleAssistant, Content: long}, {Role: RoleUser, Content: "q2"},
+			},
+			want: []string{"q2"},
+		},
+		{
+			name:  "answer is over the limit",
+			turns: []Turn{{Role: RoleUser, Content: "q1"}, {Role: RoleAssistant, Content: strings.Repeat(long, 4)}},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, contents(m.truncate(tt.turns)))
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, turnOverhead, Tokens(""))
+	assert.Equal(t, 1+turnOverhead, Tokens("KYC"))
+	assert.Equal(t, 3+turnOverhead, Tokens("Überprüfung"))
+}
+
+func contents(turns []Turn) []string {
+	var c []string
+	for _, turn := range turns {
+		c = append(c, turn.Content)
+	}
+
+	return c
+}
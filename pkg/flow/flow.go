@@ -0,0 +1,185 @@
+// Package flow describes the verification flow of the telegram bot as a state machine.
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// State is a step of the verification flow.
+type State string
+
+const (
+	StateNew         State = "new"
+	StatePoiType     State = "poi_type"
+	StatePoiCountry  State = "poi_country"
+	StatePoiFront    State = "poi_front"
+	StatePoiBack     State = "poi_back"
+	StateLiveness    State = "liveness"
+	StateSelfie      State = "selfie"
+	StatePoaCategory State = "poa_category"
+	StatePoa         State = "poa"
+	StateReview      State = "review"
+	StateDone        State = "done"
+
+	// AnyState matches every state in a transition table.
+	AnyState State = ""
+)
+
+// Event moves the verification flow from one state to another.
+type Event string
+
+const (
+	EventStart            Event = "start"
+	EventDocumentSelected Event = "document_selected"
+	EventCountrySelected  Event = "country_selected"
+	EventCategorySelected Event = "category_selected"
+	EventFrontUploaded    Event = "front_uploaded"
+	EventUploaded         Event = "uploaded"
+	EventLivenessPassed   Event = "liveness_passed"
+	EventSkipped          Event = "skipped"
+	EventRetakeFront      Event = "retake_front"
+	EventRetakeBack       Event = "retake_back"
+	EventVerified         Event = "verified"
+	EventRejected         Event = "rejected"
+)
+
+const checkPending = "pending"
+
+// ErrNotAllowed is returned when no transition matches the state, the event and the guards.
+var ErrNotAllowed = errors.New("transition not allowed")
+
+// Guard reports whether a transition can be taken for the session.
+type Guard func(s *Session) bool
+
+// Transition is a row of a transition table. A nil Guard always passes.
+type Transition struct {
+	From  State
+	Event Event
+	To    State
+	Guard Guard
+}
+
+// StateChange is a transition taken by a session, kept in the session history.
+type StateChange struct {
+	From  State     `json:"from"`
+	To    State     `json:"to"`
+	Event Event     `json:"event"`
+	At    time.Time `json:"at"`
+}
+
+// Transitions is the verification flow. Rows are checked in order and the first
+// row with a matching state, event and passing guard wins, so required steps are
+// listed in the order they are requested from the user.
+var Transitions = []Transition{
+	{StateNew, EventStart, StatePoiType, poiPending},
+	{StateNew, EventStart, StateLiveness, livenessPending},
+	{StateNew, EventStart, StateSelfie, selfiePending},
+	{StateNew, EventStart, StatePoaCategory, poaPending},
+	{StateNew, EventStart, StateReview, nil},
+
+	// the issuing country is asked only when the verification allows a choice between several countries
+	{StatePoiType, EventDocumentSelected, StatePoiCountry, countryChoice},
+	{StatePoiType, EventDocumentSelected, StatePoiFront, nil},
+	{StatePoiCountry, EventCountrySelected, StatePoiFront, nil},
+
+	{StatePoiFront, EventFrontUploaded, StatePoiBack, nil},
+	{StatePoiFront, EventUploaded, StateLiveness, livenessPending},
+	{StatePoiFront, EventUploaded, StateSelfie, selfiePending},
+	{StatePoiFront, EventUploaded, StatePoaCategory, poaPending},
+	{StatePoiFront, EventUploaded, StateReview, nil},
+
+	{StatePoiBack, EventRetakeFront, StatePoiFront, nil},
+	{StatePoiBack, EventUploaded, StateLiveness, livenessPending},
+	{StatePoiBack, EventUploaded, StateSelfie, selfiePending},
+	{StatePoiBack, EventUploaded, StatePoaCategory, poaPending},
+	{StatePoiBack, EventUploaded, StateReview, nil},
+
+	// the back side can be re-taken until the user moves past the step that follows it
+	{StateLiveness, EventRetakeBack, StatePoiBack, backJustUploaded},
+	{StateSelfie, EventRetakeBack, StatePoiBack, backJustUploaded},
+	{StatePoaCategory, EventRetakeBack, StatePoiBack, backJustUploaded},
+
+	// liveness covers face comparison, so the selfie step is never requested after it
+	{StateLiveness, EventLivenessPassed, StatePoaCategory, poaPending},
+	{StateLiveness, EventLivenessPassed, StateReview, nil},
+
+	{StateSelfie, EventUploaded, StatePoaCategory, poaPending},
+	{StateSelfie, EventUploaded, StateReview, nil},
+
+	// proof of address documents may have several pages, so the step is completed by the user once all pages are uploaded
+	{StatePoaCategory, EventCategorySelected, StatePoa, nil},
+	{StatePoaCategory, EventSkipped, StateReview, PoaOptional},
+	{StatePoa, EventUploaded, StateReview, poaUploaded},
+	{StatePoa, EventSkipped, StateReview, poaSkippable},
+
+	{AnyState, EventVerified, StateDone, nil},
+	{AnyState, EventRejected, StateDone, nil},
+}
+
+// Machine applies events to sessions according to a transition table.
+type Machine struct {
+	transitions []Transition
+}
+
+func NewMachine(transitions []Transition) *Machine {
+	return &Machine{transitions: transitions}
+}
+
+// Fire moves the session to the next state and records the change in the session history.
+func (m *Machine) Fire(s *Session, event Event, now time.Time) (StateChange, error) {
+	for _, tr := range m.transitions {
+		if (tr.From != AnyState && tr.From != s.State) || tr.Event != event {
+			continue
+		}
+		if tr.Guard != nil && !tr.Guard(s) {
+			continue
+		}
+
+		change := StateChange{From: s.State, To: tr.To, Event: event, At: now}
+		s.State = tr.To
+		s.History = append(s.History, change)
+		return change, nil
+	}
+
+	return StateChange{}, fmt.Errorf("%w: event %q in state %q", ErrNotAllowed, event, s.State)
+}
+
+func poiPending(s *Session) bool {
+	return s.Verification.Checks.DocumentMrz != nil && s.Verification.Checks.DocumentMrz.Status == checkPending
+}
+
+func livenessPending(s *Session) bool {
+	return s.Verification.Checks.Liveness != nil && s.Verification.Checks.Liveness.Status == checkPending
+}
+
+func selfiePending(s *Session) bool {
+	return s.Verification.Checks.FaceComparison != nil && s.Verification.Checks.FaceComparison.Status == checkPending
+}
+
+func poaPending(s *Session) bool {
+	return s.Verification.Checks.Poa != nil && s.Verification.Checks.Poa.Status == checkPending
+}
+
+func countryChoice(s *Session) bool {
+	return s.Verification.Settings != nil && len(s.Verification.Settings.Countries) > 1
+}
+
+func backJustUploaded(s *Session) bool {
+	return s.BackDocumentID != "" && len(s.History) > 0 && s.History[len(s.History)-1].From == StatePoiBack
+}
+
+func poaUploaded(s *Session) bool {
+	return s.Pages(StatePoa) > 0
+}
+
+// poaSkippable keeps the pages already uploaded, the step can't be skipped once it has them.
+func poaSkippable(s *Session) bool {
+	return PoaOptional(s) && s.Pages(StatePoa) == 0
+}
+
+// PoaOptional reports whether the proof of address step can be skipped.
+func PoaOptional(s *Session) bool {
+	return s.Verification.Settings == nil || !s.Verification.Settings.PoaRequired
+}
@@ -0,0 +1,125 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	dataspike "github.com/dataspike-io/docver-sdk-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachine_Fire(t *testing.T) {
+	t.Parallel()
+	pendingCheck := &dataspike.Check{Status: checkPending}
+	allChecks := dataspike.Checks{
+		DocumentMrz:    &dataspike.DocumentMrz{Check: *pendingCheck},
+		Liveness:       pendingCheck,
+		FaceComparison: pendingCheck,
+		Poa:            pendingCheck,
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		checks    dataspike.Checks
+		poa       bool
+		countries []string
+		back      string
+		uploads   []Upload
+		history   []StateChange
+		state     State
+		event     Event
+		want      State
+		err       error
+	}{
+		{name: "start with poi", checks: allChecks, state: StateNew, event: EventStart, want: StatePoiType},
+		{name: "document without country choice", checks: allChecks, countries: []string{"DE"}, state: StatePoiType, event: EventDocumentSelected, want: StatePoiFront},
+		{name: "document with country choice", checks: allChecks, countries: []string{"DE", "FR"}, state: StatePoiType, event: EventDocumentSelected, want: StatePoiCountry},
+		{name: "country selected", checks: allChecks, state: StatePoiCountry, event: EventCountrySelected, want: StatePoiFront},
+		{name: "upload before document selected", checks: allChecks, state: StatePoiType, event: EventUploaded, want: StatePoiType, err: ErrNotAllowed},
+		{name: "start with selfie", checks: dataspike.Checks{FaceComparison: pendingCheck, Poa: pendingCheck}, state: StateNew, event: EventStart, want: StateSelfie},
+		{name: "start without checks", state: StateNew, event: EventStart, want: StateReview},
+		{name: "poi front of two-sided document", checks: allChecks, state: StatePoiFront, event: EventFrontUploaded, want: StatePoiBack},
+		{name: "poi to liveness", checks: allChecks, state: StatePoiFront, event: EventUploaded, want: StateLiveness},
+		{name: "poi back to selfie", checks: dataspike.Checks{FaceComparison: pendingCheck}, state: StatePoiBack, event: EventUploaded, want: StateSelfie},
+		{name: "retake front", checks: allChecks, state: StatePoiBack, event: EventRetakeFront, want: StatePoiFront},
+		{name: "retake back too late", checks: allChecks, state: StatePoa, event: EventRetakeBack, want: StatePoa, err: ErrNotAllowed},
+		{name: "liveness skips selfie", checks: allChecks, state: StateLiveness, event: EventLivenessPassed, want: StatePoaCategory},
+		{name: "selfie to review", checks: dataspike.Checks{FaceComparison: pendingCheck}, state: StateSelfie, event: EventUploaded, want: StateReview},
+		{name: "poa category selected", checks: allChecks, state: StatePoaCategory, event: EventCategorySelected, want: StatePoa},
+		{name: "optional poa skipped before category", checks: allChecks, state: StatePoaCategory, event: EventSkipped, want: StateReview},
+		{name: "poa uploaded", checks: allChecks, uploads: []Upload{{State: StateSelfie}, {State: StatePoa}, {State: StatePoa}}, state: StatePoa, event: EventUploaded, want: StateReview},
+		{name: "poa done without pages", checks: allChecks, uploads: []Upload{{State: StateSelfie}}, state: StatePoa, event: EventUploaded, want: StatePoa, err: ErrNotAllowed},
+		{name: "optional poa skipped", checks: allChecks, state: StatePoa, event: EventSkipped, want: StateReview},
+		{name: "optional poa skipped after pages", checks: allChecks, uploads: []Upload{{State: StatePoa}}, state: StatePoa, event: EventSkipped, want: StatePoa, err: ErrNotAllowed},
+		{name: "required poa skipped", checks: allChecks, poa: true, state: StatePoa, event: EventSkipped, want: StatePoa, err: ErrNotAllowed},
+		{name: "verified from any state", checks: allChecks, state: StateSelfie, event: EventVerified, want: StateDone},
+		{name: "retake back", checks: allChecks, history: []StateChange{{From: StatePoiBack, To: StateLiveness, Event: EventUploaded}}, back: "back", state: StateLiveness, event: EventRetakeBack, want: StatePoiBack},
+		{name: "upload in review", state: StateReview, event: EventUploaded, want: StateReview, err: ErrNotAllowed},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{
+				Verification:   &dataspike.Verification{Checks: tt.checks, Settings: &dataspike.Settings{PoaRequired: tt.poa, Countries: tt.countries}},
+				State:          tt.state,
+				History:        tt.history,
+				BackDocumentID: tt.back,
+				Uploads:        tt.uploads,
+			}
+
+			change, err := NewMachine(Transitions).Fire(s, tt.event, now)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, s.State)
+			if err == nil {
+				assert.Equal(t, StateChange{From: tt.state, To: tt.want, Event: tt.event, At: now}, change)
+				assert.Equal(t, append(tt.history, change), s.History)
+			} else {
+				assert.Equal(t, tt.history, s.History)
+			}
+		})
+	}
+}
+
+func TestMachine_Resync(t *testing.T) {
+	t.Parallel()
+	pending := func(docs ...string) *dataspike.Check {
+		return &dataspike.Check{Status: checkPending, PendingDocuments: docs}
+	}
+	verified := &dataspike.Check{Status: "verified"}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		status  string
+		checks  dataspike.Checks
+		uploads []Upload
+		state   State
+		want    State
+	}{
+		{name: "final status", status: "verified", checks: dataspike.Checks{Poa: pending()}, state: StatePoa, want: StateDone},
+		{name: "keeps progress in open step", checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{Check: *pending()}}, state: StatePoiBack, want: StatePoiBack},
+		{name: "back to open document", checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{Check: *pending("poi")}, Poa: pending()}, uploads: []Upload{{State: StatePoiFront}}, state: StatePoa, want: StatePoiType},
+		{name: "uploaded document stays done", checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{Check: *pending()}, Poa: pending()}, uploads: []Upload{{State: StatePoiFront}}, state: StatePoaCategory, want: StatePoaCategory},
+		{name: "liveness completed elsewhere", checks: dataspike.Checks{Liveness: verified, FaceComparison: pending(), Poa: pending()}, state: StateLiveness, want: StatePoaCategory},
+		{name: "selfie without liveness", checks: dataspike.Checks{FaceComparison: pending()}, state: StatePoaCategory, want: StateSelfie},
+		{name: "nothing left", checks: dataspike.Checks{Liveness: verified}, state: StateLiveness, want: StateReview},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{Verification: &dataspike.Verification{}, State: tt.state, Uploads: tt.uploads}
+			v := &dataspike.Verification{Status: tt.status, Checks: tt.checks}
+
+			change := NewMachine(Transitions).Resync(s, v, now)
+			assert.Equal(t, StateChange{From: tt.state, To: tt.want, Event: EventResynced, At: now}, change)
+			assert.Equal(t, tt.want, s.State)
+			assert.Same(t, v, s.Verification)
+			if tt.want == tt.state {
+				assert.Empty(t, s.History)
+			} else {
+				assert.Equal(t, []StateChange{change}, s.History)
+			}
+		})
+	}
+}
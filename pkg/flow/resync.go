@@ -0,0 +1,114 @@
+package flow
+
+import (
+	"time"
+
+	dataspike "github.com/dataspike-io/docver-sdk-go"
+)
+
+// EventResynced is recorded when the state is rebuilt from the verification kept by dataspike.
+const EventResynced Event = "resynced"
+
+// finalStatuses are the verification statuses that leave nothing to do for the user.
+var finalStatuses = map[string]bool{
+	"verified": true,
+	"failed":   true,
+	"expired":  true,
+	"canceled": true,
+}
+
+// stage groups the states the user goes through to complete one check.
+type stage struct {
+	// entry is the state the check starts with.
+	entry  State
+	states []State
+	// upload is the state documents of the check are uploaded in, empty when the check needs no upload.
+	upload State
+	check  func(c *dataspike.Checks) *dataspike.Check
+}
+
+var stages = []stage{
+	{entry: StatePoiType, states: []State{StatePoiType, StatePoiCountry, StatePoiFront, StatePoiBack}, upload: StatePoiFront, check: func(c *dataspike.Checks) *dataspike.Check {
+		if c.DocumentMrz == nil {
+			return nil
+		}
+		return &c.DocumentMrz.Check
+	}},
+	{entry: StateLiveness, states: []State{StateLiveness}, check: func(c *dataspike.Checks) *dataspike.Check {
+		return c.Liveness
+	}},
+	{entry: StateSelfie, states: []State{StateSelfie}, upload: StateSelfie, check: func(c *dataspike.Checks) *dataspike.Check {
+		// liveness covers face comparison
+		if c.Liveness != nil {
+			return nil
+		}
+		return c.FaceComparison
+	}},
+	{entry: StatePoaCategory, states: []State{StatePoaCategory, StatePoa}, upload: StatePoa, check: func(c *dataspike.Checks) *dataspike.Check {
+		return c.Poa
+	}},
+}
+
+// Resync replaces the verification of the session with the one received from dataspike and moves
+// the session to the first check that still needs the user. The progress within the current step is
+// kept while its check is still open. The change is recorded in the history when the state differs.
+func (m *Machine) Resync(s *Session, v *dataspike.Verification, now time.Time) StateChange {
+	s.Verification = v
+	to := resyncedState(s)
+	change := StateChange{From: s.State, To: to, Event: EventResynced, At: now}
+	if to != s.State {
+		s.State = to
+		s.History = append(s.History, change)
+	}
+
+	return change
+}
+
+func resyncedState(s *Session) State {
+	if finalStatuses[s.Verification.Status] {
+		return StateDone
+	}
+
+	for _, st := range stages {
+		if !st.open(s) {
+			continue
+		}
+		if st.contains(s.State) {
+			return s.State
+		}
+		return st.entry
+	}
+
+	return StateReview
+}
+
+// open reports whether the check still needs the user. Dataspike keeps uploaded documents pending
+// until the verification is reviewed, so an upload made in the session completes the check unless
+// dataspike asks for more documents.
+func (st stage) open(s *Session) bool {
+	check := st.check(&s.Verification.Checks)
+	if check == nil || check.Status != checkPending {
+		return false
+	}
+	if st.contains(s.State) || st.upload == "" || len(check.PendingDocuments) > 0 {
+		return true
+	}
+
+	for _, u := range s.Uploads {
+		if u.State == st.upload {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (st stage) contains(state State) bool {
+	for _, s := range st.states {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,58 @@
+package flow
+
+import (
+	"time"
+
+	dataspike "github.com/dataspike-io/docver-sdk-go"
+)
+
+// Session keeps the verification of a telegram user together with the progress of the flow.
+type Session struct {
+	Verification *dataspike.Verification `json:"verification"`
+	// RefreshedAt is the time the verification was last received from dataspike.
+	RefreshedAt time.Time     `json:"refreshed_at"`
+	State       State         `json:"state"`
+	History     []StateChange `json:"history,omitempty"`
+	// DocumentType and IssuedCountry are the identity document chosen by the user before uploading it.
+	DocumentType  string `json:"document_type,omitempty"`
+	IssuedCountry string `json:"issued_country,omitempty"`
+	// PoaCategory is the kind of proof of address document chosen by the user, e.g. a utility bill.
+	PoaCategory string `json:"poa_category,omitempty"`
+	// Attempts counts the rejected uploads per step.
+	Attempts map[State]int `json:"attempts,omitempty"`
+	// Uploads is the audit trail of the documents uploaded during the session.
+	Uploads []Upload `json:"uploads,omitempty"`
+	// FrontDocumentID and BackDocumentID are the dataspike documents uploaded for the sides of the identity document.
+	FrontDocumentID string `json:"front_document_id,omitempty"`
+	BackDocumentID  string `json:"back_document_id,omitempty"`
+}
+
+// NewSession creates a session for the verification in its initial state.
+func NewSession(verification *dataspike.Verification) *Session {
+	return &Session{Verification: verification, RefreshedAt: time.Now(), State: StateNew}
+}
+
+// Upload is a document uploaded to dataspike.
+type Upload struct {
+	DocumentID string `json:"document_id"`
+	DocType    string `json:"doc_type"`
+	// State is the step the document was uploaded in.
+	State State `json:"state"`
+	// Category is the proof of address category chosen when the document was uploaded.
+	Category string `json:"category,omitempty"`
+	// SHA256 is the checksum of the file received from the user.
+	SHA256 string `json:"sha256,omitempty"`
+	// PHash is the perceptual hash of the photo, zero for other files.
+	PHash uint64    `json:"phash,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// Pages counts the documents uploaded in the state since the last upload in another state.
+func (s *Session) Pages(state State) int {
+	pages := 0
+	for i := len(s.Uploads) - 1; i >= 0 && s.Uploads[i].State == state; i-- {
+		pages++
+	}
+
+	return pages
+}
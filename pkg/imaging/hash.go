@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+)
+
+// SimilarDistance is the largest distance between the hashes of images which look the same.
+const SimilarDistance = 10
+
+// hashEdge is the longest edge the image is reduced to before it is hashed, the hash needs far less.
+const hashEdge = 256
+
+// Hash is the perceptual hash (dHash) of an image: every bit tells whether a cell of the 9x8 thumbnail
+// is brighter than the next one in its row. Resized, recompressed or slightly edited copies of the image
+// have hashes a few bits apart.
+type Hash uint64
+
+// DHash calculates the perceptual hash of the image.
+func DHash(img image.Image) Hash {
+	if img.Bounds().Empty() {
+		return 0
+	}
+
+	g := luma(img, hashEdge)
+	const w, h = 9, 8
+	var cells [w * h]float64
+	var counts [w * h]float64
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			i := y*h/g.h*w + x*w/g.w
+			cells[i] += g.at(x, y)
+			counts[i]++
+		}
+	}
+
+	for i := range cells {
+		// images narrower than the thumbnail leave some cells empty
+		if counts[i] > 0 {
+			cells[i] /= counts[i]
+		}
+	}
+
+	var hash Hash
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if cells[y*w+x] > cells[y*w+x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// Distance is the number of bits the hashes differ in.
+func (h Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// Similar reports whether the images of the hashes look the same.
+func (h Hash) Similar(other Hash) bool {
+	return h.Distance(other) <= SimilarDistance
+}
@@ -0,0 +1,33 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
+)
+
+func TestDHash(t *testing.T) {
+	t.Parallel()
+	original := noise(800, 600, 40, 128, 60)
+	hash := DHash(original)
+
+	// a smaller copy, recompressed with a low quality
+	scaled := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), original, original.Bounds(), draw.Src, nil)
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 30}))
+	copied, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+	assert.True(t, hash.Similar(DHash(copied)), "distance %d", hash.Distance(DHash(copied)))
+
+	other := gradient(800, 600)
+	assert.False(t, hash.Similar(DHash(other)), "distance %d", hash.Distance(DHash(other)))
+
+	assert.Equal(t, Hash(0), DHash(image.NewGray(image.Rect(0, 0, 0, 0))))
+	assert.Equal(t, 0, hash.Distance(hash))
+	assert.Equal(t, 64, Hash(0).Distance(^Hash(0)))
+}
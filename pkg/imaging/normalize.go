@@ -0,0 +1,229 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// formats the photos are decoded from
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// DefaultMaxEdge is the longest edge of the normalized image unless another one is given.
+	DefaultMaxEdge = 2560
+	// MinMaxEdge is the smallest longest edge images are downscaled to, the MRZ of a passport
+	// photographed as a whole page becomes unreadable below it.
+	MinMaxEdge = 1600
+	// JPEGQuality is the quality normalized images are encoded with.
+	JPEGQuality = 90
+	// MaxPixels is the largest image decoded, a few copies of it are made in memory while it is normalized.
+	MaxPixels = 50_000_000
+)
+
+// ErrTooLarge is returned for images with more than MaxPixels, e.g. decompression bombs.
+var ErrTooLarge = errors.New("image: too many pixels")
+
+// Normalize decodes the image, applies its EXIF orientation and encodes it to JPEG without any metadata,
+// downscaled to fit maxEdge. image.ErrFormat is returned for files which aren't images.
+func Normalize(data []byte, maxEdge int) ([]byte, error) {
+	img, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeJPEG(img, maxEdge)
+}
+
+// Decode decodes the image of any supported format and turns it upright according to its EXIF orientation.
+// Transparent pixels are put on a white background, as JPEG has no transparency. The size of the image
+// is read from its header first, ErrTooLarge is returned without decoding the pixels of a larger one.
+func Decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return orient(flatten(img), orientation(data)), nil
+}
+
+// EncodeJPEG downscales the image to fit maxEdge and encodes it to JPEG. Only pixels are encoded,
+// so the metadata of the original file is dropped. A zero maxEdge means DefaultMaxEdge.
+func EncodeJPEG(img image.Image, maxEdge int) ([]byte, error) {
+	if maxEdge == 0 {
+		maxEdge = DefaultMaxEdge
+	}
+	if maxEdge < MinMaxEdge {
+		maxEdge = MinMaxEdge
+	}
+
+	b := img.Bounds()
+	if w, h := b.Dx(), b.Dy(); w > maxEdge || h > maxEdge {
+		if w >= h {
+			w, h = maxEdge, h*maxEdge/w
+		} else {
+			w, h = w*maxEdge/h, maxEdge
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+		img = dst
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEGQuality})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// flatten converts the image to RGBA on a white background.
+func flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+
+	return dst
+}
+
+// orient applies the EXIF orientation, see https://www.exif.org/Exif2-2.PDF, to the image.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// rotated by 90 degrees
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// orientation reads the EXIF orientation of JPEG, TIFF and WebP files, 1 (upright) when there is none.
+func orientation(data []byte) int {
+	switch {
+	case len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8:
+		return tiffOrientation(jpegExif(data))
+	case len(data) > 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return tiffOrientation(webpExif(data))
+	default:
+		return tiffOrientation(data)
+	}
+}
+
+const exifHeader = "Exif\x00\x00"
+
+// jpegExif finds the EXIF of the JPEG file in its APP1 segment.
+func jpegExif(data []byte) []byte {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		// start of scan, the image data follows
+		if marker == 0xDA || i+2+size > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte(exifHeader)) {
+			return segment[len(exifHeader):]
+		}
+		i += 2 + size
+	}
+
+	return nil
+}
+
+// webpExif finds the EXIF of the WebP file in its EXIF chunk.
+func webpExif(data []byte) []byte {
+	for i := 12; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if i+8+size > len(data) {
+			return nil
+		}
+		if string(data[i:i+4]) == "EXIF" {
+			return bytes.TrimPrefix(data[i+8:i+8+size], []byte(exifHeader))
+		}
+		// chunks are padded to an even size
+		i += 8 + size + size%2
+	}
+
+	return nil
+}
+
+// tiffOrientation reads the orientation tag of the first IFD of the TIFF structure EXIF is kept in.
+func tiffOrientation(data []byte) int {
+	if len(data) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(data[4:]))
+	if ifd+2 > len(data) {
+		return 1
+	}
+	entries := int(order.Uint16(data[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(data) {
+			return 1
+		}
+		// the orientation is a single SHORT kept in the value field
+		if order.Uint16(data[entry:]) == 0x0112 {
+			return int(order.Uint16(data[entry+8:]))
+		}
+	}
+
+	return 1
+}
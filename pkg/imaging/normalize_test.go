@@ -0,0 +1,169 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exifOrientation is the little endian TIFF structure EXIF keeps the orientation in.
+func exifOrientation(o uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry, 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], o)
+
+	return append(tiff, entry...)
+}
+
+// withExif inserts an APP1 segment with the orientation right after the start of the JPEG image.
+func withExif(t *testing.T, img image.Image, o uint16) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	data := buf.Bytes()
+
+	payload := append([]byte(exifHeader), exifOrientation(o)...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	t.Parallel()
+	img := image.NewGray(image.Rect(0, 0, 8, 4))
+	assert.Equal(t, 6, orientation(withExif(t, img, 6)))
+
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	assert.Equal(t, 1, orientation(buf.Bytes()))
+
+	chunk := exifOrientation(8)
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x02\x00\x00\x00\x00\x00EXIF")
+	webp = binary.LittleEndian.AppendUint32(webp, uint32(len(chunk)))
+	webp = append(webp, chunk...)
+	assert.Equal(t, 8, orientation(webp))
+
+	assert.Equal(t, 3, orientation(exifOrientation(3)))
+	assert.Equal(t, 1, orientation([]byte("%PDF-1.4")))
+}
+
+func TestOrient(t *testing.T) {
+	t.Parallel()
+	// 3x2 image with distinct pixels: a b c / d e f
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Pix[i*4] = uint8('a' + i)
+	}
+	pixels := func(img *image.RGBA) string {
+		var s []byte
+		for y := 0; y < img.Rect.Dy(); y++ {
+			for x := 0; x < img.Rect.Dx(); x++ {
+				s = append(s, img.Pix[img.PixOffset(x, y)])
+			}
+			s = append(s, '/')
+		}
+		return string(s)
+	}
+
+	tests := map[int]string{
+		1: "abc/def/",
+		2: "cba/fed/",
+		3: "fed/cba/",
+		4: "def/abc/",
+		5: "ad/be/cf/",
+		6: "da/eb/fc/",
+		7: "fc/eb/da/",
+		8: "cf/be/ad/",
+	}
+	for o, want := range tests {
+		assert.Equal(t, want, pixels(orient(src, o)), "orientation %d", o)
+	}
+}
+
+// oversized is a tiny PNG whose header claims a huge image, the pixels would take gigabytes once decoded.
+func oversized(t *testing.T) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buf.Bytes()
+	// the IHDR chunk follows the 8 bytes signature: length, type, width, height, ..., CRC of type and data
+	binary.BigEndian.PutUint32(data[16:], 100_000)
+	binary.BigEndian.PutUint32(data[20:], 100_000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	return data
+}
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("rotated", func(t *testing.T) {
+		t.Parallel()
+		data, err := Normalize(withExif(t, image.NewGray(image.Rect(0, 0, 80, 40)), 6), 0)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "Exif")
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, image.Config{ColorModel: color.YCbCrModel, Width: 40, Height: 80}, cfg)
+	})
+
+	t.Run("transparent png", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		assert.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 16, 16))))
+		data, err := Normalize(buf.Bytes(), 0)
+		assert.NoError(t, err)
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		assert.NoError(t, err)
+		r, g, b, _ := img.At(8, 8).RGBA()
+		assert.Greater(t, r>>8, uint32(250))
+		assert.Greater(t, g>>8, uint32(250))
+		assert.Greater(t, b>>8, uint32(250))
+	})
+
+	t.Run("downscaled", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4000, 1000))))
+		data, err := Normalize(buf.Bytes(), 0)
+		assert.NoError(t, err)
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, 2560, cfg.Width)
+		assert.Equal(t, 640, cfg.Height)
+
+		// too small for the MRZ
+		data, err = Normalize(buf.Bytes(), 800)
+		assert.NoError(t, err)
+		cfg, _, err = image.DecodeConfig(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, MinMaxEdge, cfg.Width)
+	})
+
+	t.Run("decompression bomb", func(t *testing.T) {
+		t.Parallel()
+		data := oversized(t)
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, 100_000, cfg.Width)
+		_, err = Normalize(data, 0)
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("not an image", func(t *testing.T) {
+		t.Parallel()
+		_, err := Normalize([]byte("%PDF-1.4"), 0)
+		assert.ErrorIs(t, err, image.ErrFormat)
+	})
+}
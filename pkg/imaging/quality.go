@@ -0,0 +1,185 @@
+// Package imaging checks and prepares photos of documents before they are uploaded for verification.
+package imaging
+
+import (
+	"image"
+	"math"
+
+	// formats the photos are decoded from
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// analysisEdge is the longest edge the image is reduced to before it is measured,
+// the checks don't need more and full size photos would take too long.
+const analysisEdge = 1024
+
+// Problem is a reason the image can't be used for verification.
+type Problem string
+
+const (
+	ProblemResolution Problem = "resolution"
+	ProblemDark       Problem = "dark"
+	ProblemBright     Problem = "bright"
+	ProblemContrast   Problem = "contrast"
+	ProblemBlur       Problem = "blur"
+)
+
+// Thresholds are the limits the image is checked against, a zero limit disables its check.
+type Thresholds struct {
+	// MinShortEdge and MinLongEdge are the minimal sizes of the image in pixels.
+	MinShortEdge int
+	MinLongEdge  int
+	// MinBrightness and MaxBrightness bound the mean luma, from 0 to 255.
+	MinBrightness float64
+	MaxBrightness float64
+	// MinContrast is the minimal standard deviation of the luma.
+	MinContrast float64
+	// MinSharpness is the minimal variance of the Laplacian of the luma, blurred images have a low one.
+	MinSharpness float64
+}
+
+// DefaultThresholds reject only the images that clearly can't be read.
+var DefaultThresholds = Thresholds{
+	MinShortEdge:  480,
+	MinLongEdge:   640,
+	MinBrightness: 40,
+	MaxBrightness: 235,
+	MinContrast:   20,
+	MinSharpness:  30,
+}
+
+// Metrics describe the quality of the image.
+type Metrics struct {
+	Width  int
+	Height int
+	// Brightness is the mean luma.
+	Brightness float64
+	// Contrast is the standard deviation of the luma.
+	Contrast float64
+	// Sharpness is the variance of the Laplacian of the luma.
+	Sharpness float64
+}
+
+// Check returns the problems of the image with the given metrics, nil when the image is good enough.
+func (th Thresholds) Check(m Metrics) []Problem {
+	var problems []Problem
+	short, long := m.Width, m.Height
+	if short > long {
+		short, long = long, short
+	}
+	if short < th.MinShortEdge || long < th.MinLongEdge {
+		problems = append(problems, ProblemResolution)
+	}
+	switch {
+	case th.MinBrightness > 0 && m.Brightness < th.MinBrightness:
+		problems = append(problems, ProblemDark)
+	case th.MaxBrightness > 0 && m.Brightness > th.MaxBrightness:
+		problems = append(problems, ProblemBright)
+	}
+	if m.Contrast < th.MinContrast {
+		problems = append(problems, ProblemContrast)
+	}
+	if m.Sharpness < th.MinSharpness {
+		problems = append(problems, ProblemBlur)
+	}
+
+	return problems
+}
+
+// Measure calculates the metrics of the image.
+func Measure(img image.Image) Metrics {
+	b := img.Bounds()
+	m := Metrics{Width: b.Dx(), Height: b.Dy()}
+	if m.Width == 0 || m.Height == 0 {
+		return m
+	}
+
+	g := luma(img, analysisEdge)
+	var sum, sumSq float64
+	for _, v := range g.pix {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(g.pix))
+	m.Brightness = sum / n
+	m.Contrast = math.Sqrt(math.Max(sumSq/n-m.Brightness*m.Brightness, 0))
+	m.Sharpness = laplacianVariance(g)
+
+	return m
+}
+
+// grayImage keeps the luma of the pixels row by row.
+type grayImage struct {
+	w, h int
+	pix  []float64
+}
+
+func (g *grayImage) at(x, y int) float64 {
+	return g.pix[y*g.w+x]
+}
+
+// luma reduces the image to the luma of its pixels, averaging boxes of pixels so the longest edge fits maxEdge.
+func luma(img image.Image, maxEdge int) *grayImage {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	scale := 1
+	for w/scale > maxEdge || h/scale > maxEdge {
+		scale++
+	}
+
+	g := &grayImage{w: (w + scale - 1) / scale, h: (h + scale - 1) / scale}
+	g.pix = make([]float64, g.w*g.h)
+	counts := make([]float64, len(g.pix))
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := (y - b.Min.Y) / scale * g.w
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var v float64
+			switch img := img.(type) {
+			case *image.YCbCr:
+				// JPEG photos keep the luma as is
+				v = float64(img.Y[img.YOffset(x, y)])
+			case *image.RGBA:
+				p := img.Pix[img.PixOffset(x, y):]
+				v = grayWeight(uint32(p[0])*257, uint32(p[1])*257, uint32(p[2])*257)
+			default:
+				r, gr, bl, _ := img.At(x, y).RGBA()
+				v = grayWeight(r, gr, bl)
+			}
+			i := row + (x-b.Min.X)/scale
+			g.pix[i] += v
+			counts[i]++
+		}
+	}
+	for i := range g.pix {
+		g.pix[i] /= counts[i]
+	}
+
+	return g
+}
+
+// grayWeight converts 16-bit color components to 8-bit luma with the same weights as color.GrayModel.
+func grayWeight(r, g, b uint32) float64 {
+	return (19595*float64(r) + 38470*float64(g) + 7471*float64(b)) / (1 << 16) / 257
+}
+
+// laplacianVariance is the variance of the 4-neighbour Laplacian over the inner pixels of the image.
+func laplacianVariance(g *grayImage) float64 {
+	if g.w < 3 || g.h < 3 {
+		return 0
+	}
+
+	var sum, sumSq float64
+	for y := 1; y < g.h-1; y++ {
+		for x := 1; x < g.w-1; x++ {
+			l := g.at(x-1, y) + g.at(x+1, y) + g.at(x, y-1) + g.at(x, y+1) - 4*g.at(x, y)
+			sum += l
+			sumSq += l * l
+		}
+	}
+	n := float64((g.w - 2) * (g.h - 2))
+	mean := sum / n
+
+	return sumSq/n - mean*mean
+}
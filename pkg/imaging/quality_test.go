@@ -0,0 +1,81 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noise fills an image with random gray blocks of pixels around the mean, a sharp image with a lot of detail.
+func noise(w, h, block int, mean, spread uint8) *image.Gray {
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y += block {
+		for x := 0; x < w; x += block {
+			v := mean - spread + uint8(rnd.Intn(2*int(spread)+1))
+			draw.Draw(img, image.Rect(x, y, x+block, y+block), image.NewUniform(color.Gray{Y: v}), image.Point{}, draw.Src)
+		}
+	}
+
+	return img
+}
+
+// gradient changes smoothly from black to white, an image without any sharp edge.
+func gradient(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 255 / w)})
+		}
+	}
+
+	return img
+}
+
+func TestThresholds_Check(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		img  image.Image
+		want []Problem
+	}{
+		{name: "good", img: noise(800, 600, 1, 128, 60)},
+		{name: "portrait", img: noise(600, 800, 1, 128, 60)},
+		{name: "thumbnail", img: noise(320, 240, 1, 128, 60), want: []Problem{ProblemResolution}},
+		{name: "dark", img: noise(800, 600, 1, 20, 15), want: []Problem{ProblemDark, ProblemContrast}},
+		{name: "bright", img: noise(800, 600, 1, 245, 10), want: []Problem{ProblemBright, ProblemContrast}},
+		{name: "blurred", img: gradient(800, 600), want: []Problem{ProblemBlur}},
+		{name: "large", img: noise(3000, 2000, 8, 128, 60)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, DefaultThresholds.Check(Measure(tt.img)))
+		})
+	}
+}
+
+func TestThresholds_Check_disabled(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, Thresholds{}.Check(Measure(gradient(100, 100))))
+}
+
+func TestMeasure(t *testing.T) {
+	t.Parallel()
+	img := image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420)
+	for i := range img.Y {
+		img.Y[i] = uint8(i%2) * 200
+	}
+
+	m := Measure(img)
+	assert.Equal(t, 4, m.Width)
+	assert.Equal(t, 4, m.Height)
+	assert.Equal(t, 100.0, m.Brightness)
+	assert.Equal(t, 100.0, m.Contrast)
+	assert.Greater(t, m.Sharpness, 0.0)
+}
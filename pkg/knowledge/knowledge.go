@@ -0,0 +1,194 @@
+// Package knowledge searches a local knowledge base of markdown and FAQ documents, so the answers
+// of the expert rely on the actual document requirements rather than the general knowledge of the model.
+package knowledge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// indexVersion changes whenever the index format or the tokenization does, indexes of other versions must be rebuilt.
+const indexVersion = 1
+
+// BM25 parameters: k1 limits the weight of repeated terms, b how much long passages are penalized.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// stopWords are too common to tell passages apart.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"can": true, "do": true, "does": true, "for": true, "from": true, "how": true, "i": true, "if": true,
+	"in": true, "is": true, "it": true, "me": true, "my": true, "of": true, "on": true, "or": true,
+	"so": true, "that": true, "the": true, "this": true, "to": true, "was": true, "what": true,
+	"when": true, "which": true, "with": true, "you": true, "your": true,
+}
+
+// Result is a passage found for the query.
+type Result struct {
+	Passage Passage
+	Score   float64
+}
+
+// Index is the BM25 index of the passages. It is built by the knowledge-index command and loaded by the bot.
+type Index struct {
+	Version  int       `json:"version"`
+	Passages []Passage `json:"passages"`
+	// Terms are the term frequencies of every passage.
+	Terms []map[string]int `json:"terms"`
+	// Lengths are the numbers of terms in every passage.
+	Lengths []int `json:"lengths"`
+	// DocFreq is the number of passages every term appears in.
+	DocFreq map[string]int `json:"doc_freq"`
+	AvgLen  float64        `json:"avg_len"`
+}
+
+// Build indexes the passages.
+func Build(passages []Passage) *Index {
+	idx := &Index{
+		Version:  indexVersion,
+		Passages: passages,
+		Terms:    make([]map[string]int, len(passages)),
+		Lengths:  make([]int, len(passages)),
+		DocFreq:  make(map[string]int),
+	}
+
+	total := 0
+	for i, p := range passages {
+		terms := make(map[string]int)
+		// the title is a part of the passage, questions often repeat it
+		tokens := Tokenize(p.Title + " " + p.Text)
+		for _, token := range tokens {
+			terms[token]++
+		}
+		for term := range terms {
+			idx.DocFreq[term]++
+		}
+		idx.Terms[i] = terms
+		idx.Lengths[i] = len(tokens)
+		total += len(tokens)
+	}
+	if len(passages) > 0 {
+		idx.AvgLen = float64(total) / float64(len(passages))
+	}
+
+	return idx
+}
+
+// BuildDir indexes the documents of the directory.
+func BuildDir(dir string) (*Index, error) {
+	passages, err := ReadDir(os.DirFS(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	return Build(passages), nil
+}
+
+// Search returns up to limit passages matching the query, the best match first.
+// Passages sharing no terms with the query aren't returned.
+func (idx *Index) Search(query string, limit int) []Result {
+	terms := Tokenize(query)
+	if len(terms) == 0 || limit <= 0 {
+		return nil
+	}
+
+	n := float64(len(idx.Passages))
+	var results []Result
+	for i, p := range idx.Passages {
+		score := 0.0
+		seen := make(map[string]bool)
+		for _, term := range terms {
+			tf := float64(idx.Terms[i][term])
+			if tf == 0 || seen[term] {
+				continue
+			}
+			seen[term] = true
+			df := float64(idx.DocFreq[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := 1 - b + b*float64(idx.Lengths[i])/idx.AvgLen
+			score += idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+		if score > 0 {
+			results = append(results, Result{Passage: p, Score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// Save writes the index to the file atomically, so the bot never loads it half written.
+func (idx *Index) Save(path string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads the index saved at the path.
+func Load(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	err = json.Unmarshal(data, &idx)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("knowledge index version %d is not supported, rebuild the index", idx.Version)
+	}
+	if len(idx.Terms) != len(idx.Passages) || len(idx.Lengths) != len(idx.Passages) {
+		return nil, errors.New("knowledge index is corrupted, rebuild the index")
+	}
+
+	return &idx, nil
+}
+
+// Tokenize splits the text into lower case terms without the stop words.
+func Tokenize(text string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if stopWords[word] {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+
+	return tokens
+}
@@ -0,0 +1,118 @@
+package knowledge
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var knowledgeBase = fstest.MapFS{
+	"documents/poi.md": {Data: []byte(`Intro about identity documents.
+
+## Accepted identity documents
+
+We accept passports, national ID cards and residence permits with an MRZ code.
+
+## Germany
+
+German national ID cards (Personalausweis) are accepted, both sides must be uploaded.
+`)},
+	"documents/poa.md": {Data: []byte(`# Proof of address
+
+Utility bills and bank statements not older than 3 months are accepted as proof of address.
+`)},
+	"faq.txt":    {Data: []byte("How long does the verification take?\n\nUsually a few minutes.")},
+	"image.png":  {Data: []byte{0x89, 'P', 'N', 'G'}},
+	"README.MD":  {Data: []byte("# About\n\nThe knowledge base of the expert.")},
+	"notes/.txt": {Data: []byte("")},
+}
+
+func TestSplit(t *testing.T) {
+	t.Parallel()
+	passages := Split("documents/poi.md", knowledgeBase["documents/poi.md"].Data)
+	assert.Equal(t, []Passage{
+		{ID: "documents/poi.md#1", Source: "documents/poi.md", Title: "poi", Text: "Intro about identity documents."},
+		{ID: "documents/poi.md#2", Source: "documents/poi.md", Title: "Accepted identity documents", Text: "We accept passports, national ID cards and residence permits with an MRZ code."},
+		{ID: "documents/poi.md#3", Source: "documents/poi.md", Title: "Germany", Text: "German national ID cards (Personalausweis) are accepted, both sides must be uploaded."},
+	}, passages)
+
+	paragraph := strings.Repeat("word ", 100)
+	long := "## Long ##\n\n" + strings.Repeat(paragraph+"\n\n", 5) + "#hashtag isn't a heading"
+	passages = Split("long.md", []byte(long))
+	assert.Len(t, passages, 3)
+	for _, p := range passages {
+		assert.Equal(t, "Long", p.Title)
+		assert.LessOrEqual(t, len(p.Text), maxPassageLength)
+	}
+	assert.True(t, strings.HasSuffix(passages[2].Text, "#hashtag isn't a heading"))
+}
+
+func TestReadDir(t *testing.T) {
+	t.Parallel()
+	passages, err := ReadDir(knowledgeBase)
+	assert.NoError(t, err)
+
+	var ids []string
+	for _, p := range passages {
+		ids = append(ids, p.ID)
+	}
+	assert.Equal(t, []string{"README.MD#1", "documents/poa.md#1", "documents/poi.md#1", "documents/poi.md#2", "documents/poi.md#3", "faq.txt#1"}, ids)
+}
+
+func TestIndex_Search(t *testing.T) {
+	t.Parallel()
+	passages, err := ReadDir(knowledgeBase)
+	assert.NoError(t, err)
+	idx := Build(passages)
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{name: "country", query: "Which documents are accepted in Germany?", limit: 2, want: []string{"documents/poi.md#3", "documents/poi.md#2"}},
+		{name: "title", query: "proof of address", limit: 3, want: []string{"documents/poa.md#1"}},
+		{name: "case insensitive", query: "MRZ", limit: 3, want: []string{"documents/poi.md#2"}},
+		{name: "no match", query: "cryptocurrency", limit: 3, want: nil},
+		{name: "stop words", query: "what is the", limit: 3, want: nil},
+		{name: "no limit", query: "Germany", limit: 0, want: nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for _, r := range idx.Search(tt.query, tt.limit) {
+				got = append(got, r.Passage.ID)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIndex_Save(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "kb")
+	assert.NoError(t, os.MkdirAll(filepath.Join(docs, "documents"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(docs, "documents", "poi.md"), knowledgeBase["documents/poi.md"].Data, 0o644))
+
+	idx, err := BuildDir(docs)
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "index.json")
+	assert.NoError(t, idx.Save(path))
+
+	loaded, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, idx, loaded)
+	assert.Equal(t, idx.Search("Germany", 1), loaded.Search("Germany", 1))
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version":0}`), 0o644))
+	_, err = Load(path)
+	assert.EqualError(t, err, "knowledge index version 0 is not supported, rebuild the index")
+}
@@ -0,0 +1,125 @@
+package knowledge
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxPassageLength is the length, in bytes, sections are split at, so a passage stays focused
+// and a few of them fit the prompt.
+const maxPassageLength = 1200
+
+// extensions are the files read from the knowledge base directory.
+var extensions = map[string]bool{".md": true, ".markdown": true, ".txt": true}
+
+// Passage is a part of a knowledge base document, usually a section under a heading.
+type Passage struct {
+	// ID identifies the passage, e.g. "documents/poa.md#2".
+	ID string `json:"id"`
+	// Source is the path of the document relative to the knowledge base directory.
+	Source string `json:"source"`
+	// Title is the heading of the section, or the name of the document for the text before the first heading.
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// ReadDir splits the markdown and text documents found in the directory tree into passages.
+func ReadDir(fsys fs.FS) ([]Passage, error) {
+	var passages []Passage
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !extensions[strings.ToLower(path.Ext(name))] {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		passages = append(passages, Split(filepath.ToSlash(name), data)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return passages, nil
+}
+
+// Split splits the markdown document into passages at its headings. Sections longer than
+// maxPassageLength are split further at paragraphs, every part keeps the heading of the section.
+func Split(source string, document []byte) []Passage {
+	title := strings.TrimSuffix(path.Base(source), path.Ext(source))
+	var passages []Passage
+	var section strings.Builder
+	flush := func() {
+		for _, text := range chunks(section.String()) {
+			passages = append(passages, Passage{
+				ID:     source + "#" + strconv.Itoa(len(passages)+1),
+				Source: source,
+				Title:  title,
+				Text:   text,
+			})
+		}
+		section.Reset()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(document))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(document)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if heading, ok := parseHeading(line); ok {
+			flush()
+			title = heading
+			continue
+		}
+		section.WriteString(line)
+		section.WriteByte('\n')
+	}
+	flush()
+
+	return passages
+}
+
+// parseHeading returns the text of the ATX heading, e.g. "## Proof of address".
+func parseHeading(line string) (string, bool) {
+	trimmed := strings.TrimLeft(line, "#")
+	level := len(line) - len(trimmed)
+	if level == 0 || level > 6 || (trimmed != "" && trimmed[0] != ' ' && trimmed[0] != '\t') {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(trimmed), "#")), true
+}
+
+// chunks splits the text at paragraphs into parts of up to maxPassageLength. Longer paragraphs are kept whole.
+func chunks(text string) []string {
+	var parts []string
+	var part strings.Builder
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if part.Len() > 0 && part.Len()+len(paragraph)+2 > maxPassageLength {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		if part.Len() > 0 {
+			part.WriteString("\n\n")
+		}
+		part.WriteString(paragraph)
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+
+	return parts
+}
@@ -0,0 +1,203 @@
+package redact
+
+import (
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Kind is a kind of personal data.
+type Kind string
+
+const (
+	KindMRZ            Kind = "mrz"
+	KindDocumentNumber Kind = "document_number"
+	KindIBAN           Kind = "iban"
+	KindEmail          Kind = "email"
+	KindPhone          Kind = "phone"
+)
+
+// placeholders replace the personal data, so the text still reads naturally.
+var placeholders = map[Kind]string{
+	KindMRZ:            "[MRZ]",
+	KindDocumentNumber: "[DOCUMENT_NUMBER]",
+	KindIBAN:           "[IBAN]",
+	KindEmail:          "[EMAIL]",
+	KindPhone:          "[PHONE]",
+}
+
+var (
+	// mrzRun matches the characters of the machine readable zone wherever they are, e.g. inline in a sentence.
+	// The run is split into the lines of TD1 (30), TD2 (36) or TD3 (44) documents by mrzLines.
+	mrzRun = regexp.MustCompile(`[A-Z0-9<]{30,}`)
+	// mrzName matches the name line of the zone, e.g. "P<UTOERIKSSON<<ANNA<MARIA<<<<<<<<<<<<<<<<<<<".
+	mrzName = regexp.MustCompile(`^[A-Z<]*[A-Z]<<[A-Z<]*$`)
+	iban    = regexp.MustCompile(`\b[A-Za-z]{2}[0-9]{2}(?: ?[A-Za-z0-9]{4}){2,7}(?: ?[A-Za-z0-9]{1,3})?\b`)
+	email   = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)
+	// labeledNumber matches a number following its label, e.g. "passport no: 123456789".
+	labeledNumber = regexp.MustCompile(`(?i)\b(?:passport|document|id|identity card|licen[cs]e|permit)(?:\s+(?:number|no\.?|#))?\s*[:#-]?\s*([A-Z0-9]{5,12})\b`)
+	// documentNumber matches the numbers of documents mixing letters and digits, e.g. "C01X00T47".
+	documentNumber = regexp.MustCompile(`\b[A-Z0-9]{6,12}\b`)
+	phone          = regexp.MustCompile(`(?:\+|\()?\b[0-9][0-9 ().-]{6,}[0-9]\b`)
+	date           = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$|^[0-9]{2}[./-][0-9]{2}[./-][0-9]{4}$`)
+)
+
+// PII replaces MRZ lines, document numbers, IBANs, emails and phone numbers with placeholders, e.g. "[EMAIL]",
+// and counts the replacements of every kind. The detection favours privacy: numbers which merely look like
+// a document or a phone number are replaced too, while MRZ lines and IBANs are replaced only when their
+// check digits are valid.
+func PII(text string) (string, map[Kind]int) {
+	counts := make(map[Kind]int)
+	replace := func(kind Kind, re *regexp.Regexp, valid func(match string) bool) {
+		text = re.ReplaceAllStringFunc(text, func(match string) string {
+			if valid != nil && !valid(match) {
+				return match
+			}
+			counts[kind]++
+			return placeholders[kind]
+		})
+	}
+
+	text = mrzRun.ReplaceAllStringFunc(text, func(run string) string {
+		lines := mrzLines(run)
+		for i, line := range lines {
+			if isMRZ(line) {
+				counts[KindMRZ]++
+				lines[i] = placeholders[KindMRZ]
+			}
+		}
+		return strings.Join(lines, "")
+	})
+	replace(KindIBAN, iban, validIBAN)
+	replace(KindEmail, email, nil)
+	text = labeledNumber.ReplaceAllStringFunc(text, func(match string) string {
+		number := labeledNumber.FindStringSubmatch(match)[1]
+		if !strings.ContainsAny(number, "0123456789") {
+			return match
+		}
+		counts[KindDocumentNumber]++
+		return strings.TrimSuffix(match, number) + placeholders[KindDocumentNumber]
+	})
+	replace(KindPhone, phone, validPhone)
+	replace(KindDocumentNumber, documentNumber, looksLikeDocumentNumber)
+
+	return text, counts
+}
+
+// mrzLines splits the run into the lines of the zone, the lines pasted without a separator too.
+// Runs of other lengths aren't a zone and are returned as a single line.
+func mrzLines(run string) []string {
+	for _, n := range []int{44, 36, 30} {
+		if len(run)%n != 0 {
+			continue
+		}
+		lines := make([]string, 0, len(run)/n)
+		for i := 0; i < len(run); i += n {
+			lines = append(lines, run[i:i+n])
+		}
+		return lines
+	}
+
+	return []string{run}
+}
+
+// isMRZ reports whether the line is a name line or has a valid check digit where the format puts one.
+func isMRZ(line string) bool {
+	if !strings.Contains(line, "<") {
+		return false
+	}
+	if mrzName.MatchString(line) {
+		return true
+	}
+
+	// fields followed by their check digits: the document number and the date of birth
+	var fields [][2]int
+	switch len(line) {
+	case 30:
+		fields = [][2]int{{5, 14}, {0, 6}}
+	default:
+		fields = [][2]int{{0, 9}, {13, 19}}
+	}
+	for _, f := range fields {
+		value := line[f[0]:f[1]]
+		if strings.Trim(value, "<") != "" && checkDigit(value) == line[f[1]] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDigit computes the ICAO 9303 check digit of the field.
+func checkDigit(field string) byte {
+	weights := [3]int{7, 3, 1}
+	sum := 0
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		var v int
+		switch {
+		case c >= '0' && c <= '9':
+			v = int(c - '0')
+		case c >= 'A' && c <= 'Z':
+			v = int(c-'A') + 10
+		}
+		sum += v * weights[i%3]
+	}
+
+	return byte('0' + sum%10)
+}
+
+// validIBAN checks the length and the ISO 13616 checksum of the IBAN.
+func validIBAN(match string) bool {
+	code := strings.ToUpper(strings.ReplaceAll(match, " ", ""))
+	if len(code) < 15 || len(code) > 34 {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, r := range code[4:] + code[:4] {
+		if r >= 'A' && r <= 'Z' {
+			digits.WriteString(big.NewInt(int64(r-'A') + 10).String())
+			continue
+		}
+		digits.WriteRune(r)
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validPhone accepts 8 to 15 digits, numbers without an international prefix need at least 9 of them.
+// Dates are left alone.
+func validPhone(match string) bool {
+	if date.MatchString(match) {
+		return false
+	}
+	digits := 0
+	for _, r := range match {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	international := strings.HasPrefix(match, "+") || strings.HasPrefix(match, "00")
+
+	return digits <= 15 && (digits >= 9 || international && digits >= 8)
+}
+
+// looksLikeDocumentNumber accepts the codes mixing letters with at least four digits, e.g. "C01X00T47".
+func looksLikeDocumentNumber(match string) bool {
+	letters, digits := 0, 0
+	for _, r := range match {
+		if unicode.IsDigit(r) {
+			digits++
+		} else {
+			letters++
+		}
+	}
+
+	return letters > 0 && digits >= 4
+}
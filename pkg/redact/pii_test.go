@@ -0,0 +1,96 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPII(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		text   string
+		want   string
+		counts map[Kind]int
+	}{
+		{
+			name:   "td3 mrz",
+			text:   "My MRZ:\nP<UTOERIKSSON<<ANNA<MARIA<<<<<<<<<<<<<<<<<<<\nL898902C36UTO7408122F1204159ZE184226B<<<<<10\nis it valid?",
+			want:   "My MRZ:\n[MRZ]\n[MRZ]\nis it valid?",
+			counts: map[Kind]int{KindMRZ: 2},
+		},
+		{
+			name:   "td1 mrz",
+			text:   "I<UTOD231458907<<<<<<<<<<<<<<<\n7408122F1204159UTO<<<<<<<<<<<6\nERIKSSON<<ANNA<MARIA<<<<<<<<<<",
+			want:   "[MRZ]\n[MRZ]\n[MRZ]",
+			counts: map[Kind]int{KindMRZ: 3},
+		},
+		{
+			name:   "inline mrz",
+			text:   "Is P<UTOERIKSSON<<ANNA<MARIA<<<<<<<<<<<<<<<<<<< fine? The second line is L898902C36UTO7408122F1204159ZE184226B<<<<<10.",
+			want:   "Is [MRZ] fine? The second line is [MRZ].",
+			counts: map[Kind]int{KindMRZ: 2},
+		},
+		{
+			name:   "space joined mrz",
+			text:   "MRZ: I<UTOD231458907<<<<<<<<<<<<<<< 7408122F1204159UTO<<<<<<<<<<<6 ERIKSSON<<ANNA<MARIA<<<<<<<<<<",
+			want:   "MRZ: [MRZ] [MRZ] [MRZ]",
+			counts: map[Kind]int{KindMRZ: 3},
+		},
+		{
+			name:   "mrz lines without a separator",
+			text:   "P<UTOERIKSSON<<ANNA<MARIA<<<<<<<<<<<<<<<<<<<L898902C36UTO7408122F1204159ZE184226B<<<<<10",
+			want:   "[MRZ][MRZ]",
+			counts: map[Kind]int{KindMRZ: 2},
+		},
+		{
+			name:   "invalid check digits",
+			text:   "L898902C46UTO7408123F1204159ZE184226B<<<<<10",
+			want:   "L898902C46UTO7408123F1204159ZE184226B<<<<<10",
+			counts: map[Kind]int{},
+		},
+		{
+			name:   "document numbers",
+			text:   "My passport number: 123456789 and the ID card is C01X00T47, see section 4 of GDPR.",
+			want:   "My passport number: [DOCUMENT_NUMBER] and the ID card is [DOCUMENT_NUMBER], see section 4 of GDPR.",
+			counts: map[Kind]int{KindDocumentNumber: 2},
+		},
+		{
+			name:   "iban",
+			text:   "Is DE89 3704 0044 0532 0130 00 fine? Or GB82WEST12345698765432? Not DE00 3704 0044 0532 0130 00.",
+			want:   "Is [IBAN] fine? Or [IBAN]? Not DE00 3704 0044 0532 0130 00.",
+			counts: map[Kind]int{KindIBAN: 2},
+		},
+		{
+			name:   "email and phones",
+			text:   "Write to anna.eriksson+kyc@example.com or call +49 30 1234567, (555) 123-4567. Born 1974-08-12, 3 months ago.",
+			want:   "Write to [EMAIL] or call [PHONE], [PHONE]. Born 1974-08-12, 3 months ago.",
+			counts: map[Kind]int{KindEmail: 1, KindPhone: 2},
+		},
+		{
+			name:   "nothing to redact",
+			text:   "Which documents are accepted in Germany for KYC?",
+			want:   "Which documents are accepted in Germany for KYC?",
+			counts: map[Kind]int{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, counts := PII(tt.text)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.counts, counts)
+		})
+	}
+}
+
+func Test_checkDigit(t *testing.T) {
+	t.Parallel()
+	// the specimen of ICAO 9303
+	assert.Equal(t, byte('6'), checkDigit("L898902C3"))
+	assert.Equal(t, byte('2'), checkDigit("740812"))
+	assert.Equal(t, byte('9'), checkDigit("120415"))
+	assert.Equal(t, byte('7'), checkDigit("D23145890"))
+}
@@ -0,0 +1,170 @@
+// Package redact removes secrets, e.g. API tokens, from errors and logs, and personal data
+// from the text sent to third parties.
+package redact
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Mask replaces the secrets.
+const Mask = "********"
+
+// Redactor replaces known secrets with Mask. The zero value and nil redact nothing.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// New creates a redactor of the secrets, empty ones are skipped. The URL-encoded forms
+// of the secrets are redacted as well, as secrets often end up in URLs.
+func New(secrets ...string) *Redactor {
+	var forms []string
+	seen := make(map[string]bool)
+	for _, s := range secrets {
+		for _, form := range []string{s, url.QueryEscape(s), url.PathEscape(s)} {
+			if form != "" && !seen[form] {
+				seen[form] = true
+				forms = append(forms, form)
+			}
+		}
+	}
+	if len(forms) == 0 {
+		return &Redactor{}
+	}
+
+	// longer secrets go first, so a secret containing another one is masked as a whole
+	sort.Slice(forms, func(i, j int) bool { return len(forms[i]) > len(forms[j]) })
+	pairs := make([]string, 0, 2*len(forms))
+	for _, form := range forms {
+		pairs = append(pairs, form, Mask)
+	}
+
+	return &Redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// String replaces the secrets in the string.
+func (r *Redactor) String(s string) string {
+	if r == nil || r.replacer == nil {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// Error wraps the error, so its message has no secrets. The original error is still available
+// to errors.Is and errors.As.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+
+	return &redactedError{msg: msg, err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Writer redacts everything written to w, e.g. the output of the log package.
+// Every write is redacted on its own, so a secret must be written at once.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &writer{r: r, w: w}
+}
+
+type writer struct {
+	r *Redactor
+	w io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	_, err := io.WriteString(w.w, w.r.String(string(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Handler wraps the slog handler, so the message and the attributes of records have no secrets.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	return &handler{r: r, h: h}
+}
+
+type handler struct {
+	r *Redactor
+	h slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.r.String(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.attr(a))
+		return true
+	})
+
+	return h.h.Handle(ctx, redacted)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, h.attr(a))
+	}
+
+	return &handler{r: h.r, h: h.h.WithAttrs(redacted)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{r: h.r, h: h.h.WithGroup(name)}
+}
+
+// attr redacts the value of the attribute. Values other than strings and groups, e.g. errors, are
+// formatted the way handlers would print them and replaced with the redacted text when they hold a secret.
+func (h *handler) attr(a slog.Attr) slog.Attr {
+	a.Key = h.r.String(a.Key)
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(h.r.String(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]slog.Attr, 0, len(group))
+		for _, ga := range group {
+			redacted = append(redacted, h.attr(ga))
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		text := fmt.Sprint(v.Any())
+		if redacted := h.r.String(text); redacted != text {
+			a.Value = slog.StringValue(redacted)
+		} else {
+			a.Value = v
+		}
+	default:
+		a.Value = v
+	}
+
+	return a
+}
@@ -0,0 +1,93 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	tgToken = "123456:ABC-def/ghi+jkl"
+	dsToken = "ds_secret_token"
+)
+
+type credentials struct {
+	Token string
+}
+
+type tokenValuer struct{}
+
+func (tokenValuer) LogValue() slog.Value {
+	return slog.StringValue("token " + dsToken)
+}
+
+func TestRedactor_String(t *testing.T) {
+	t.Parallel()
+	r := New(tgToken, dsToken, "")
+
+	link := "https://api.telegram.org/file/bot" + tgToken + "/photos/file_1.jpg"
+	assert.Equal(t, "https://api.telegram.org/file/bot"+Mask+"/photos/file_1.jpg", r.String(link))
+	assert.Equal(t, "token="+Mask, r.String("token="+url.QueryEscape(tgToken)))
+	assert.Equal(t, "nothing to hide", r.String("nothing to hide"))
+
+	var nilRedactor *Redactor
+	assert.Equal(t, dsToken, nilRedactor.String(dsToken))
+	assert.Equal(t, dsToken, New().String(dsToken))
+}
+
+func TestRedactor_Error(t *testing.T) {
+	t.Parallel()
+	r := New(tgToken)
+	cause := &url.Error{Op: "Get", URL: "https://api.telegram.org/file/bot" + tgToken + "/doc.pdf", Err: errors.New("timeout")}
+	err := r.Error(fmt.Errorf("download failed: %w", cause))
+
+	assert.NotContains(t, err.Error(), tgToken)
+	var urlErr *url.Error
+	assert.ErrorAs(t, err, &urlErr)
+
+	plain := errors.New("plain")
+	assert.Same(t, plain, r.Error(plain))
+	assert.Nil(t, r.Error(nil))
+}
+
+// TestRedactor_sinks writes the secrets every way the bot logs to every sink it logs to.
+func TestRedactor_sinks(t *testing.T) {
+	t.Parallel()
+	r := New(tgToken, dsToken)
+	link := "https://api.telegram.org/file/bot" + tgToken + "/doc.pdf"
+	cause := &url.Error{Op: "Get", URL: link, Err: errors.New("timeout")}
+
+	logTo := func(logger *slog.Logger) {
+		logger = logger.With("client_token", dsToken)
+		logger.Info("downloading "+link,
+			"error", cause,
+			"url", link,
+			"credentials", credentials{Token: dsToken},
+			"valuer", tokenValuer{},
+			slog.Group("request", "url", link, "attempt", 1),
+		)
+		logger.WithGroup("dataspike").Warn("request failed", "token", dsToken)
+	}
+
+	var text, json, std bytes.Buffer
+	logTo(slog.New(r.Handler(slog.NewTextHandler(&text, nil))))
+	logTo(slog.New(r.Handler(slog.NewJSONHandler(&json, nil))))
+
+	stdLogger := log.New(r.Writer(&std), "", 0)
+	stdLogger.Printf("failed to create BotAPI: %s", cause)
+	logTo(slog.New(slog.NewTextHandler(r.Writer(&std), nil)))
+
+	for name, out := range map[string]string{"text": text.String(), "json": json.String(), "std": std.String()} {
+		assert.NotEmpty(t, out, name)
+		assert.NotContains(t, out, tgToken, name)
+		assert.NotContains(t, out, url.PathEscape(tgToken), name)
+		assert.NotContains(t, out, dsToken, name)
+		assert.Contains(t, out, Mask, name)
+	}
+}
@@ -0,0 +1,150 @@
+// Package reminder schedules messages reminding users about verifications they haven't finished.
+package reminder
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Kind is the reason a reminder is sent for.
+type Kind string
+
+const (
+	// KindIdle reminds users who stopped in the middle of the flow.
+	KindIdle Kind = "idle"
+	// KindExpiry warns users that the verification expires soon.
+	KindExpiry Kind = "expiry"
+)
+
+const defaultInterval = 10 * time.Second
+
+// Job is a reminder due at a point in time.
+type Job struct {
+	TgID string    `json:"tg_id"`
+	Kind Kind      `json:"kind"`
+	At   time.Time `json:"at"`
+}
+
+// ID identifies the job, a user has at most one job of every kind.
+func (j Job) ID() string {
+	return j.TgID + ":" + string(j.Kind)
+}
+
+// Store keeps scheduled jobs. Implementations must be safe for concurrent use.
+type Store interface {
+	// Save adds the job or replaces the job with the same ID.
+	Save(ctx context.Context, job Job) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Job, error)
+}
+
+// Handler sends the reminder. Jobs are removed from the store before the handler is called,
+// so a failed reminder isn't sent again.
+type Handler func(ctx context.Context, job Job) error
+
+type Option func(s *Scheduler)
+
+// WithInterval is a Option that allows you set how often the scheduler looks for due jobs.
+// Default value is 10 seconds.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// WithErrorHandler is a Option that allows you receive errors of the store and the handler.
+func WithErrorHandler(onError func(job Job, err error)) Option {
+	return func(s *Scheduler) {
+		s.onError = onError
+	}
+}
+
+// Scheduler runs jobs kept in a store once they are due.
+type Scheduler struct {
+	store    Store
+	handler  Handler
+	interval time.Duration
+	onError  func(job Job, err error)
+	now      func() time.Time
+}
+
+func NewScheduler(store Store, handler Handler, options ...Option) *Scheduler {
+	s := &Scheduler{
+		store:    store,
+		handler:  handler,
+		interval: defaultInterval,
+		onError:  func(Job, error) {},
+		now:      time.Now,
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// Schedule adds the job, replacing the job of the same kind scheduled for the user before.
+func (s *Scheduler) Schedule(ctx context.Context, job Job) error {
+	return s.store.Save(ctx, job)
+}
+
+// Cancel removes the jobs of the given kinds scheduled for the user, all of them when no kind is given.
+func (s *Scheduler) Cancel(ctx context.Context, tgID string, kinds ...Kind) error {
+	if len(kinds) == 0 {
+		kinds = []Kind{KindIdle, KindExpiry}
+	}
+	for _, kind := range kinds {
+		err := s.store.Delete(ctx, Job{TgID: tgID, Kind: kind}.ID())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run checks for due jobs until the context is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue runs the jobs that are due, the earliest first.
+func (s *Scheduler) RunDue(ctx context.Context) {
+	jobs, err := s.store.List(ctx)
+	if err != nil {
+		s.onError(Job{}, err)
+		return
+	}
+
+	now := s.now()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].At.Before(jobs[j].At) })
+	for _, job := range jobs {
+		if job.At.After(now) {
+			break
+		}
+
+		err = s.store.Delete(ctx, job.ID())
+		if err != nil {
+			s.onError(job, err)
+			continue
+		}
+		err = s.handler(ctx, job)
+		if err != nil {
+			s.onError(job, err)
+		}
+	}
+}
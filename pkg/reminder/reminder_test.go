@@ -0,0 +1,90 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler_RunDue(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var sent []Job
+	var failed []error
+	store := NewMemoryStore()
+	s := NewScheduler(store, func(_ context.Context, job Job) error {
+		sent = append(sent, job)
+		if job.TgID == "3" {
+			return errors.New("send error")
+		}
+		return nil
+	}, WithErrorHandler(func(_ Job, err error) { failed = append(failed, err) }))
+	s.now = func() time.Time { return now }
+
+	late := Job{TgID: "1", Kind: KindIdle, At: now.Add(-time.Minute)}
+	early := Job{TgID: "3", Kind: KindExpiry, At: now.Add(-time.Hour)}
+	future := Job{TgID: "2", Kind: KindIdle, At: now.Add(time.Minute)}
+	for _, job := range []Job{late, early, future} {
+		assert.NoError(t, s.Schedule(ctx, job))
+	}
+
+	s.RunDue(ctx)
+	assert.Equal(t, []Job{early, late}, sent)
+	assert.Equal(t, []error{errors.New("send error")}, failed)
+
+	jobs, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Job{future}, jobs)
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := NewMemoryStore()
+	s := NewScheduler(store, nil)
+
+	assert.NoError(t, s.Schedule(ctx, Job{TgID: "1", Kind: KindIdle}))
+	assert.NoError(t, s.Schedule(ctx, Job{TgID: "1", Kind: KindExpiry}))
+	assert.NoError(t, s.Schedule(ctx, Job{TgID: "2", Kind: KindIdle}))
+
+	assert.NoError(t, s.Cancel(ctx, "1", KindIdle))
+	jobs, _ := store.List(ctx)
+	assert.Len(t, jobs, 2)
+
+	assert.NoError(t, s.Cancel(ctx, "1"))
+	jobs, _ = store.List(ctx)
+	assert.Equal(t, []Job{{TgID: "2", Kind: KindIdle}}, jobs)
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "reminders.json")
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(ctx, Job{TgID: "1", Kind: KindIdle, At: at}))
+	assert.NoError(t, store.Save(ctx, Job{TgID: "1", Kind: KindExpiry, At: at}))
+	assert.NoError(t, store.Delete(ctx, Job{TgID: "1", Kind: KindExpiry}.ID()))
+
+	restarted, err := NewFileStore(path)
+	assert.NoError(t, err)
+	jobs, err := restarted.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Job{{TgID: "1", Kind: KindIdle, At: at}}, jobs)
+
+	// the same job doesn't rewrite the file
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, restarted.Save(ctx, Job{TgID: "1", Kind: KindIdle, At: at}))
+	assert.NoFileExists(t, path)
+	assert.NoError(t, restarted.Save(ctx, Job{TgID: "1", Kind: KindIdle, At: at.Add(time.Minute)}))
+	assert.FileExists(t, path)
+}
@@ -0,0 +1,146 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MemoryStore keeps jobs in memory, they are lost on restart.
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+func (m *MemoryStore) Save(_ context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[job.ID()] = job
+	return nil
+}
+
+func (m *MemoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *MemoryStore) List(_ context.Context) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// FileStore keeps jobs in memory and writes them to a JSON file on every change,
+// so scheduled reminders survive restarts.
+type FileStore struct {
+	path string
+
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewFileStore loads the jobs saved at the path. A missing file is created on the first change.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{path: path, jobs: make(map[string]Job)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	err = json.Unmarshal(data, &jobs)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		f.jobs[job.ID()] = job
+	}
+
+	return f, nil
+}
+
+// Save writes the file only when the job is new or due at another time.
+func (f *FileStore) Save(_ context.Context, job Job) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if saved, ok := f.jobs[job.ID()]; ok && saved.At.Equal(job.At) {
+		return nil
+	}
+	f.jobs[job.ID()] = job
+	return f.flush()
+}
+
+func (f *FileStore) Delete(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.jobs[id]; !ok {
+		return nil
+	}
+	delete(f.jobs, id)
+	return f.flush()
+}
+
+func (f *FileStore) List(_ context.Context) ([]Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	jobs := make([]Job, 0, len(f.jobs))
+	for _, job := range f.jobs {
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// flush replaces the file atomically, so a crash never leaves it half written.
+func (f *FileStore) flush() error {
+	jobs := make([]Job, 0, len(f.jobs))
+	for _, job := range f.jobs {
+		jobs = append(jobs, job)
+	}
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
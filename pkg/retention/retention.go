@@ -0,0 +1,137 @@
+// Package retention deletes chat messages once they have been kept long enough.
+package retention
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DeletionLimit is how long telegram lets bots delete messages after they have been sent.
+const DeletionLimit = 48 * time.Hour
+
+// maxWindow leaves the janitor an hour to delete a message before the limit.
+const maxWindow = DeletionLimit - time.Hour
+
+const defaultInterval = time.Minute
+
+// ErrTooOld is reported for messages which can't be deleted anymore, e.g. after a long downtime.
+var ErrTooOld = errors.New("message is older than 48 hours and can't be deleted")
+
+// Message identifies a message in a chat.
+type Message struct {
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// Store keeps the messages to delete. Implementations must be safe for concurrent use.
+type Store interface {
+	Add(ctx context.Context, msg Message) error
+	// Due returns the messages sent at or before the time.
+	Due(ctx context.Context, sentBefore time.Time) ([]Message, error)
+	Remove(ctx context.Context, msg Message) error
+}
+
+// Deleter deletes the message from the chat.
+type Deleter func(ctx context.Context, msg Message) error
+
+type Option func(j *Janitor)
+
+// WithInterval is a Option that allows you set how often the janitor looks for messages to delete.
+// Default value is 1 minute.
+func WithInterval(interval time.Duration) Option {
+	return func(j *Janitor) {
+		if interval > 0 {
+			j.interval = interval
+		}
+	}
+}
+
+// WithFailureHandler is a Option that allows you receive the messages the janitor couldn't delete.
+func WithFailureHandler(onFailure func(msg Message, err error)) Option {
+	return func(j *Janitor) {
+		j.onFailure = onFailure
+	}
+}
+
+// Janitor deletes recorded messages once the retention window has passed.
+type Janitor struct {
+	store     Store
+	delete    Deleter
+	window    time.Duration
+	interval  time.Duration
+	onFailure func(msg Message, err error)
+	now       func() time.Time
+}
+
+// NewJanitor creates a janitor deleting messages the window after they have been sent. Windows longer
+// than telegram allows to delete messages in are shortened to fit it.
+func NewJanitor(store Store, deleter Deleter, window time.Duration, options ...Option) *Janitor {
+	if window > maxWindow {
+		window = maxWindow
+	}
+
+	j := &Janitor{
+		store:     store,
+		delete:    deleter,
+		window:    window,
+		interval:  defaultInterval,
+		onFailure: func(Message, error) {},
+		now:       time.Now,
+	}
+	for _, option := range options {
+		option(j)
+	}
+
+	return j
+}
+
+// Record remembers the message to delete it later.
+func (j *Janitor) Record(ctx context.Context, msg Message) error {
+	return j.store.Add(ctx, msg)
+}
+
+// Run deletes due messages until the context is done.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.Sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes the messages kept longer than the window. Messages are forgotten whether or not
+// they have been deleted, failures are reported to the failure handler.
+func (j *Janitor) Sweep(ctx context.Context) {
+	now := j.now()
+	messages, err := j.store.Due(ctx, now.Add(-j.window))
+	if err != nil {
+		j.onFailure(Message{}, err)
+		return
+	}
+
+	for _, msg := range messages {
+		err = j.store.Remove(ctx, msg)
+		if err != nil {
+			j.onFailure(msg, err)
+			continue
+		}
+
+		if now.Sub(msg.SentAt) >= DeletionLimit {
+			j.onFailure(msg, ErrTooOld)
+			continue
+		}
+		err = j.delete(ctx, msg)
+		if err != nil {
+			j.onFailure(msg, err)
+		}
+	}
+}
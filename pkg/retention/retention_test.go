@@ -0,0 +1,82 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJanitor_Sweep(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+
+	var deleted []int
+	failed := map[int]error{}
+	store := NewMemoryStore()
+	j := NewJanitor(store, func(_ context.Context, msg Message) error {
+		if msg.MessageID == 3 {
+			return errors.New("message can't be deleted")
+		}
+		deleted = append(deleted, msg.MessageID)
+		return nil
+	}, time.Hour, WithFailureHandler(func(msg Message, err error) { failed[msg.MessageID] = err }))
+	j.now = func() time.Time { return now }
+
+	for _, msg := range []Message{
+		{ChatID: 1, MessageID: 1, SentAt: now.Add(-2 * time.Hour)},
+		{ChatID: 1, MessageID: 2, SentAt: now.Add(-30 * time.Minute)},
+		{ChatID: 1, MessageID: 3, SentAt: now.Add(-time.Hour)},
+		{ChatID: 1, MessageID: 4, SentAt: now.Add(-DeletionLimit)},
+	} {
+		assert.NoError(t, j.Record(ctx, msg))
+	}
+
+	j.Sweep(ctx)
+	assert.Equal(t, []int{1}, deleted)
+	assert.Equal(t, map[int]error{3: errors.New("message can't be deleted"), 4: ErrTooOld}, failed)
+
+	left, err := store.Due(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{ChatID: 1, MessageID: 2, SentAt: now.Add(-30 * time.Minute)}}, left)
+}
+
+func TestNewJanitor_window(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, time.Hour, NewJanitor(nil, nil, time.Hour).window)
+	assert.Equal(t, maxWindow, NewJanitor(nil, nil, 72*time.Hour).window)
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "messages.json")
+	sentAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(ctx, Message{ChatID: 1, MessageID: 2, SentAt: sentAt.Add(time.Minute)}))
+	assert.NoError(t, store.Add(ctx, Message{ChatID: 1, MessageID: 1, SentAt: sentAt}))
+	assert.NoError(t, store.Add(ctx, Message{ChatID: 1, MessageID: 3, SentAt: sentAt}))
+	assert.NoError(t, store.Remove(ctx, Message{ChatID: 1, MessageID: 3}))
+
+	// the messages sent before the restart are still deleted, or reported after a long downtime
+	restarted, err := NewFileStore(path)
+	assert.NoError(t, err)
+	messages, err := restarted.Due(ctx, sentAt.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{
+		{ChatID: 1, MessageID: 1, SentAt: sentAt},
+		{ChatID: 1, MessageID: 2, SentAt: sentAt.Add(time.Minute)},
+	}, messages)
+
+	var failed []error
+	j := NewJanitor(restarted, func(context.Context, Message) error { return nil }, time.Hour, WithFailureHandler(func(_ Message, err error) { failed = append(failed, err) }))
+	j.now = func() time.Time { return sentAt.Add(3 * DeletionLimit) }
+	j.Sweep(ctx)
+	assert.Equal(t, []error{ErrTooOld, ErrTooOld}, failed)
+}
@@ -0,0 +1,162 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// key identifies the message in the stores.
+type key struct {
+	chatID    int64
+	messageID int
+}
+
+func (m Message) key() key {
+	return key{chatID: m.ChatID, messageID: m.MessageID}
+}
+
+// due lists the messages sent at or before the time, the oldest first.
+func due(messages map[key]Message, sentBefore time.Time) []Message {
+	var list []Message
+	for _, msg := range messages {
+		if !msg.SentAt.After(sentBefore) {
+			list = append(list, msg)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].SentAt.Equal(list[j].SentAt) {
+			return list[i].SentAt.Before(list[j].SentAt)
+		}
+		return list[i].MessageID < list[j].MessageID
+	})
+
+	return list
+}
+
+// MemoryStore keeps messages in memory, they are lost on restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages map[key]Message
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{messages: make(map[key]Message)}
+}
+
+func (m *MemoryStore) Add(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages[msg.key()] = msg
+	return nil
+}
+
+func (m *MemoryStore) Due(_ context.Context, sentBefore time.Time) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return due(m.messages, sentBefore), nil
+}
+
+func (m *MemoryStore) Remove(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.messages, msg.key())
+	return nil
+}
+
+// FileStore keeps messages in memory and writes them to a JSON file on every change, so the messages
+// sent before a restart are deleted after it, or reported as too old after a long downtime.
+type FileStore struct {
+	path string
+
+	mu       sync.Mutex
+	messages map[key]Message
+}
+
+// NewFileStore loads the messages saved at the path. A missing file is created on the first change.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{path: path, messages: make(map[key]Message)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+	err = json.Unmarshal(data, &messages)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range messages {
+		f.messages[msg.key()] = msg
+	}
+
+	return f, nil
+}
+
+func (f *FileStore) Add(_ context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages[msg.key()] = msg
+	return f.flush()
+}
+
+func (f *FileStore) Due(_ context.Context, sentBefore time.Time) ([]Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return due(f.messages, sentBefore), nil
+}
+
+func (f *FileStore) Remove(_ context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.messages[msg.key()]; !ok {
+		return nil
+	}
+	delete(f.messages, msg.key())
+	return f.flush()
+}
+
+// flush replaces the file atomically, so a crash never leaves it half written.
+func (f *FileStore) flush() error {
+	messages := make([]Message, 0, len(f.messages))
+	for _, msg := range f.messages {
+		messages = append(messages, msg)
+	}
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
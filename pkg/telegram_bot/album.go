@@ -0,0 +1,278 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dataspike-io/docver-sdk-go"
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultAlbumWindow = time.Second
+
+// albumCollector buffers messages of a media group, which telegram delivers as separate updates,
+// and hands them over as a whole once no new message of the group arrived within the window.
+type albumCollector struct {
+	window time.Duration
+	done   <-chan struct{}
+	ready  chan []*tgbotapi.Message
+
+	mu     sync.Mutex
+	groups map[string]*album
+}
+
+type album struct {
+	messages []*tgbotapi.Message
+	timer    *time.Timer
+}
+
+func newAlbumCollector(window time.Duration, done <-chan struct{}) *albumCollector {
+	if window <= 0 {
+		window = defaultAlbumWindow
+	}
+
+	return &albumCollector{
+		window: window,
+		done:   done,
+		ready:  make(chan []*tgbotapi.Message),
+		groups: make(map[string]*album),
+	}
+}
+
+func (c *albumCollector) add(message *tgbotapi.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := message.MediaGroupID
+	a, ok := c.groups[id]
+	if !ok {
+		a = &album{}
+		a.timer = time.AfterFunc(c.window, func() { c.flush(id, a) })
+		c.groups[id] = a
+	} else {
+		a.timer.Reset(c.window)
+	}
+	a.messages = append(a.messages, message)
+}
+
+// flush hands the album over. A timer reset by add after it had fired fires once more, the album
+// has been handed over by then and the stale timer finds another album of the group or none.
+func (c *albumCollector) flush(id string, a *album) {
+	c.mu.Lock()
+	if c.groups[id] != a {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.groups, id)
+	c.mu.Unlock()
+
+	sort.Slice(a.messages, func(i, j int) bool { return a.messages[i].MessageID < a.messages[j].MessageID })
+
+	select {
+	case c.ready <- a.messages:
+	case <-c.done:
+	}
+}
+
+// albumSize is the number of files a single album may contain in the state of the session.
+func albumSize(s *flow.Session) int {
+	switch {
+	case s.State == flow.StatePoiFront && (s.DocumentType == "" || documentTwoSided(s)):
+		return 2
+	case s.State == flow.StatePoa:
+		// the same limit as for the pages sent one by one
+		return max(maxPoaPages-s.Pages(flow.StatePoa), 0)
+	}
+
+	return 1
+}
+
+// ParseAlbum handles the files of a media group as a single upload, e.g. both sides of a document.
+func (t *TelegramBot) ParseAlbum(ctx context.Context, messages []*tgbotapi.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	if len(messages) == 1 {
+		return t.ParseDocument(ctx, messages[0])
+	}
+
+	for _, message := range messages {
+		defer t.deleteIncoming(message)
+	}
+
+	chatID := messages[0].From.ID
+	session, err := t.cache.GetSession(ctx, strconv.FormatInt(chatID, 10))
+	if err != nil {
+		return err
+	}
+
+	st := stepFor(session.State)
+	if st.choice {
+		return t.sendHTML(chatID, poiChooseFirst, nil)
+	}
+	docType := st.docType
+	if docType == "" {
+		return errors.New("status not supported for upload document")
+	}
+	if t.attemptsLeft(session) <= 0 {
+		return t.sendHTML(chatID, uploadAttemptsExhausted, contactUsKeyboard)
+	}
+	if size := albumSize(session); len(messages) > size {
+		_, err = t.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(albumTooLarge, size)))
+		return err
+	}
+
+	// every file is checked before the first one is uploaded, dataspike can't take an upload back
+	files := make([]*preparedFile, 0, len(messages))
+	for _, message := range messages {
+		filename, url, err := t.getLink(message)
+		if err != nil {
+			return t.uploadFailed(ctx, chatID, session, err)
+		}
+		file, err := t.fetchDocument(ctx, session, filename, url)
+		if err != nil {
+			return t.uploadFailed(ctx, chatID, session, err)
+		}
+		if duplicateInAlbum(files, file, docType) {
+			return t.uploadFailed(ctx, chatID, session, &duplicatePhotoError{of: session.State})
+		}
+		files = append(files, file)
+	}
+
+	docs := make([]*dataspike.Document, 0, len(files))
+	for _, file := range files {
+		doc, err := t.uploadPrepared(docType, albumSide(session.State, docs), file, session)
+		if err != nil {
+			return t.albumPartlyUploaded(ctx, chatID, docType, session, docs, len(files), err)
+		}
+		docs = append(docs, doc)
+	}
+
+	if docType == Poa {
+		return t.poaPagesUploaded(ctx, chatID, session)
+	}
+
+	return t.poiPairUploaded(ctx, chatID, session, docs[0], docs[1])
+}
+
+// albumPartlyUploaded keeps the files dataspike has accepted before it failed to upload the next one,
+// so the user sends only the rest of the album again.
+func (t *TelegramBot) albumPartlyUploaded(ctx context.Context, chatID int64, docType string, session *flow.Session, docs []*dataspike.Document, total int, uploadErr error) error {
+	if len(docs) == 0 {
+		return t.uploadFailed(ctx, chatID, session, uploadErr)
+	}
+
+	err := t.sendHTML(chatID, fmt.Sprintf(albumPartlyReceived, len(docs), total), nil)
+	if err != nil {
+		return err
+	}
+
+	// the accepted files move the step on as if they were sent one by one
+	state := session.State
+	if docType == Poa {
+		err = t.poaPagesUploaded(ctx, chatID, session)
+	} else {
+		err = t.poiUploaded(ctx, chatID, session, docs[0])
+	}
+	if err != nil {
+		return err
+	}
+	if session.State != state && stepFor(session.State).docType != docType {
+		// the files left out aren't needed any more
+		return nil
+	}
+
+	return t.uploadFailed(ctx, chatID, session, uploadErr)
+}
+
+// poiPairUploaded completes the document step with both sides uploaded at once, in any order.
+func (t *TelegramBot) poiPairUploaded(ctx context.Context, chatID int64, session *flow.Session, a, b *dataspike.Document) error {
+	tgID := strconv.FormatInt(chatID, 10)
+	front, back, ok := pairSides(a, b)
+	if !ok {
+		// the uploads are kept in the audit trail
+		err := t.cache.SetSession(ctx, tgID, session)
+		if err != nil {
+			return err
+		}
+
+		return t.sendHTML(chatID, albumSidesMismatch, nil)
+	}
+
+	session.FrontDocumentID = front.DocumentId
+	err := t.fire(ctx, tgID, session, flow.EventFrontUploaded)
+	if err != nil {
+		return err
+	}
+	session.BackDocumentID = back.DocumentId
+	err = t.fire(ctx, tgID, session, flow.EventUploaded)
+	if err != nil {
+		return err
+	}
+
+	err = t.sendHTML(chatID, poiBothSidesReceived, retakeBackKeyboard)
+	if err != nil {
+		return err
+	}
+
+	return t.nextCheck(chatID, session)
+}
+
+// pairSides orders two uploaded documents by the side detected by dataspike.
+// When the side isn't detected the order of the album is kept.
+func pairSides(a, b *dataspike.Document) (front, back *dataspike.Document, ok bool) {
+	sideA, sideB := detectedSide(a), detectedSide(b)
+	switch {
+	case sideA != "" && sideA == sideB:
+		return nil, nil, false
+	case sideA == Back || sideB == Front:
+		return b, a, true
+	default:
+		return a, b, true
+	}
+}
+
+// uploadSide is the side of the document a file sent on its own is uploaded as in the state,
+// files of other steps have no side.
+func uploadSide(state flow.State) string {
+	switch state {
+	case flow.StatePoiFront:
+		return Front
+	case flow.StatePoiBack:
+		return Back
+	}
+
+	return ""
+}
+
+// albumSide is the side of the document the next file of the album is uploaded as. The sides of an album
+// may come in any order, so the first one is left for dataspike to detect and the other one is declared
+// as the opposite of the detected side.
+func albumSide(state flow.State, uploaded []*dataspike.Document) string {
+	if state != flow.StatePoiFront || len(uploaded) == 0 {
+		return ""
+	}
+
+	switch detectedSide(uploaded[0]) {
+	case Front:
+		return Back
+	case Back:
+		return Front
+	}
+
+	return ""
+}
+
+func detectedSide(doc *dataspike.Document) string {
+	if doc.DetectedDocumentSide == nil {
+		return ""
+	}
+
+	return *doc.DetectedDocumentSide
+}
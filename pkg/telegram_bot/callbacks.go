@@ -0,0 +1,81 @@
+package telegram_bot
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// callbackTTL is how long a handled button press is remembered to filter out duplicates.
+const callbackTTL = 10 * time.Minute
+
+// callbackGuard remembers handled button messages, so repeated presses on the same
+// keyboard are handled only once. The zero value is ready to use.
+type callbackGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// acquire reports whether the key is seen for the first time within callbackTTL.
+func (g *callbackGuard) acquire(key string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.seen == nil {
+		g.seen = make(map[string]time.Time)
+	}
+	for k, at := range g.seen {
+		if now.Sub(at) > callbackTTL {
+			delete(g.seen, k)
+		}
+	}
+
+	if _, ok := g.seen[key]; ok {
+		return false
+	}
+	g.seen[key] = now
+	return true
+}
+
+// release forgets the key, so the button can be pressed again, e.g. after its handler failed.
+func (g *callbackGuard) release(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.seen, key)
+}
+
+// callbackKey identifies the message that carried the pressed button.
+// Inline messages have no chat message attached, so the query id is used instead.
+func callbackKey(callbackQuery *tgbotapi.CallbackQuery) string {
+	if callbackQuery.Message == nil || callbackQuery.Message.Chat == nil {
+		return "query:" + callbackQuery.ID
+	}
+
+	return strconv.FormatInt(callbackQuery.Message.Chat.ID, 10) + ":" + strconv.Itoa(callbackQuery.Message.MessageID)
+}
+
+// answerCallback stops the loading indicator on the client and optionally shows a toast.
+func (t *TelegramBot) answerCallback(callbackQuery *tgbotapi.CallbackQuery, text string) error {
+	_, err := t.bot.Request(tgbotapi.NewCallback(callbackQuery.ID, text))
+	return err
+}
+
+// removeKeyboard edits the message that carried the button, so stale buttons can't be pressed again.
+// When text is not empty the message text is replaced as well.
+func (t *TelegramBot) removeKeyboard(callbackQuery *tgbotapi.CallbackQuery, text string) error {
+	if callbackQuery.Message == nil || callbackQuery.Message.Chat == nil {
+		return nil
+	}
+
+	chatID, messageID := callbackQuery.Message.Chat.ID, callbackQuery.Message.MessageID
+	if text != "" {
+		_, err := t.bot.Request(tgbotapi.NewEditMessageText(chatID, messageID, text))
+		return err
+	}
+
+	_, err := t.bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, emptyKeyboard))
+	return err
+}
@@ -0,0 +1,52 @@
+package telegram_bot
+
+import "sync"
+
+// chatQueues run the work of every user in order, one piece at a time, the work of different users
+// runs in parallel. The updates, the webhooks and the reminders of a user change the same session,
+// while a slow answer of the LLM or a slow download holds up only its own user.
+type chatQueues struct {
+	mu sync.Mutex
+	// queues is the work waiting for every user, a user is in the map while the work of the user runs.
+	queues map[int64][]func()
+}
+
+// do queues the work behind the work of the user queued before, it doesn't wait for it.
+func (q *chatQueues) do(chatID int64, work func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.queues == nil {
+		q.queues = make(map[int64][]func())
+	}
+	queue, running := q.queues[chatID]
+	q.queues[chatID] = append(queue, work)
+	if !running {
+		go q.run(chatID)
+	}
+}
+
+// wait queues the work like do and waits for it to be done.
+func (q *chatQueues) wait(chatID int64, work func() error) error {
+	done := make(chan error, 1)
+	q.do(chatID, func() { done <- work() })
+
+	return <-done
+}
+
+func (q *chatQueues) run(chatID int64) {
+	for {
+		q.mu.Lock()
+		queue := q.queues[chatID]
+		if len(queue) == 0 {
+			delete(q.queues, chatID)
+			q.mu.Unlock()
+			return
+		}
+		work := queue[0]
+		q.queues[chatID] = queue[1:]
+		q.mu.Unlock()
+
+		work()
+	}
+}
@@ -0,0 +1,227 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Visibility defines who can see and use a command.
+type Visibility int
+
+const (
+	// VisibilityPublic commands are available to everyone.
+	VisibilityPublic Visibility = iota
+	// VisibilitySandbox commands are available only when the bot runs in sandbox mode.
+	VisibilitySandbox
+	// VisibilityAdmin commands are available only to chats configured with WithAdmins.
+	VisibilityAdmin
+)
+
+// CommandHandler handles a message with a bot command.
+type CommandHandler func(ctx context.Context, message *tgbotapi.Message) error
+
+// Command describes a bot command used both for dispatching and for the Telegram command menu.
+type Command struct {
+	// Name is the command without the leading slash, e.g. "help".
+	Name string
+	// Description is shown in the command menu.
+	Description string
+	Handler     CommandHandler
+	Visibility  Visibility
+	// Hidden commands are dispatched but not shown in the command menu.
+	Hidden bool
+	// Languages limits the menu entry to users with the given IETF language codes.
+	// An empty list shows the command to users with any language.
+	Languages []string
+}
+
+// commandName is the form of the command names telegram accepts.
+var commandName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// commandRegistry keeps the commands of a single bot instance.
+type commandRegistry struct {
+	commands []Command
+	byName   map[string]int
+	// err is why commands were rejected by register, the bot isn't created with them.
+	err error
+}
+
+func newCommandRegistry(commands ...Command) *commandRegistry {
+	r := &commandRegistry{byName: make(map[string]int)}
+	r.register(commands...)
+	return r
+}
+
+// register adds commands to the registry. A command with an already known name replaces the old one.
+// Invalid commands aren't added, the reason is kept in err.
+func (r *commandRegistry) register(commands ...Command) {
+	for _, c := range commands {
+		err := c.validate()
+		if err != nil {
+			if r.err != nil {
+				err = errors.Join(r.err, err)
+			}
+			r.err = err
+			continue
+		}
+		if i, ok := r.byName[c.Name]; ok {
+			r.commands[i] = c
+			continue
+		}
+		r.byName[c.Name] = len(r.commands)
+		r.commands = append(r.commands, c)
+	}
+}
+
+func (r *commandRegistry) lookup(name string) (Command, bool) {
+	i, ok := r.byName[name]
+	if !ok {
+		return Command{}, false
+	}
+
+	return r.commands[i], true
+}
+
+// menus builds SetMyCommands requests for every scope and language used by the registered commands.
+// The default scope gets public commands (and sandbox ones when dev is set), admin chats get admin commands on top.
+func (r *commandRegistry) menus(dev bool, admins []int64) []tgbotapi.SetMyCommandsConfig {
+	languages := []string{""}
+	seen := make(map[string]bool)
+	for _, c := range r.commands {
+		for _, l := range c.Languages {
+			if !seen[l] {
+				seen[l] = true
+				languages = append(languages, l)
+			}
+		}
+	}
+	sort.Strings(languages[1:])
+
+	var configs []tgbotapi.SetMyCommandsConfig
+	for _, l := range languages {
+		configs = append(configs, tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeDefault(), l, r.menu(l, dev, false)...))
+	}
+	for _, admin := range admins {
+		for _, l := range languages {
+			configs = append(configs, tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeChat(admin), l, r.menu(l, dev, true)...))
+		}
+	}
+
+	return configs
+}
+
+func (r *commandRegistry) menu(language string, dev, admin bool) []tgbotapi.BotCommand {
+	commands := make([]tgbotapi.BotCommand, 0, len(r.commands))
+	for _, c := range r.commands {
+		if c.Hidden || !c.allowed(dev, admin) || !c.listedFor(language) {
+			continue
+		}
+		commands = append(commands, tgbotapi.BotCommand{Command: c.Name, Description: c.Description})
+	}
+
+	return commands
+}
+
+func (c Command) validate() error {
+	switch {
+	case !commandName.MatchString(c.Name):
+		return fmt.Errorf("command %q: the name must be 1-32 lowercase letters, digits or underscores", c.Name)
+	case c.Handler == nil:
+		return fmt.Errorf("command %q has no handler", c.Name)
+	case !c.Hidden && c.Description == "":
+		return fmt.Errorf("command %q has no description for the menu", c.Name)
+	}
+
+	return nil
+}
+
+func (c Command) allowed(dev, admin bool) bool {
+	switch c.Visibility {
+	case VisibilitySandbox:
+		return dev
+	case VisibilityAdmin:
+		return admin
+	default:
+		return true
+	}
+}
+
+func (c Command) listedFor(language string) bool {
+	if len(c.Languages) == 0 {
+		return true
+	}
+	for _, l := range c.Languages {
+		if l == language {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultCommands returns the commands the bot supports out of the box.
+func (t *TelegramBot) defaultCommands() []Command {
+	return []Command{
+		{Name: "start", Handler: t.startCommand, Hidden: true},
+		{Name: "help", Description: "Help", Handler: t.helpCommand},
+		{Name: "start_verification", Description: "Start verification", Handler: t.startVerificationCommand},
+		{Name: "status", Description: "Verification status", Handler: t.statusCommand},
+		{Name: "resume", Description: "Resume verification", Handler: t.resumeCommand},
+		{Name: "customize_bot", Description: "Customize bot", Handler: t.customizeBotCommand},
+		{Name: "ask_expert", Description: "Ask expert", Handler: t.askExpertCommand},
+		{Name: "reset_expert", Description: "Start a new conversation with expert", Handler: t.resetExpertCommand},
+		{Name: "cancel", Description: "Cancel", Handler: t.cancelCommand},
+		{Name: "create_verification", Description: "Create new verification", Handler: t.createVerificationCommand, Visibility: VisibilitySandbox},
+	}
+}
+
+// syncCommands publishes the command menu to Telegram and deletes the menus published before
+// for the admins and languages which are gone.
+func (t *TelegramBot) syncCommands() error {
+	configs := t.commands.menus(t.dev, t.admins)
+	scopes := make([]MenuScope, 0, len(configs))
+	published := make(map[MenuScope]bool, len(configs))
+	for _, config := range configs {
+		_, err := t.bot.Request(config)
+		if err != nil {
+			return err
+		}
+		scope := MenuScope{ChatID: config.Scope.ChatID, Language: config.LanguageCode}
+		scopes = append(scopes, scope)
+		published[scope] = true
+	}
+
+	if t.menus == nil {
+		return nil
+	}
+	previous, err := t.menus.LoadMenus()
+	if err != nil {
+		return err
+	}
+	for _, scope := range previous {
+		if published[scope] {
+			continue
+		}
+		_, err = t.bot.Request(tgbotapi.NewDeleteMyCommandsWithScopeAndLanguage(scope.botScope(), scope.Language))
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.menus.SaveMenus(scopes)
+}
+
+func (t *TelegramBot) isAdmin(tgID int64) bool {
+	for _, admin := range t.admins {
+		if admin == tgID {
+			return true
+		}
+	}
+
+	return false
+}
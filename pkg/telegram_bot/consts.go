@@ -3,10 +3,11 @@ package telegram_bot
 import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 const (
-	startText             = "Welcome to our identity verification chatbot, powered by DataSpike.io! \nWe understand the importance of keeping your personal data safe and secure, which is why we want to assure you that we do not cache any data. \nYour information will be automatically removed from the chat within 1 hour for your privacy and security. \nIf you have any questions or concerns about the verification process, please don't hesitate to contact us. \n\nWe're here to help.\n\n/start_verification - Start new document verification process\n/help - Display help information\n/cancel - Cancel ongoing verification\n/ask_expert - Ask AI expert\n/customize_bot - Integrate bot to your platform"
+	startText             = "Welcome to our identity verification chatbot, powered by DataSpike.io! \nWe understand the importance of keeping your personal data safe and secure, which is why we want to assure you that we do not cache any data. \nYour information will be automatically removed from the chat within 1 hour for your privacy and security. \nIf you have any questions or concerns about the verification process, please don't hesitate to contact us. \n\nWe're here to help.\n\n/start_verification - Start new document verification process\n/status - Show the progress of your verification\n/resume - Continue your verification from where you left off\n/help - Display help information\n/cancel - Cancel ongoing verification\n/ask_expert - Ask AI expert\n/reset_expert - Start a new conversation with AI expert\n/customize_bot - Integrate bot to your platform"
 	helpText              = "Thank you for using our KYC verification bot! To ensure a smooth and easy verification process, please read the following instructions carefully:\n\n- What is MRZ? The Machine Readable Zone (MRZ) is a series of characters found on most passports and IDs that contain important personal information. - Please ensure that your ID document contains an MRZ before uploading it.\nWhich documents support MRZ? Most passports and government-issued IDs, such as driver's licenses, national ID cards, and residence permits, contain an MRZ. Please check your document to confirm.\n- How to upload high-quality photos? For the best results, please ensure that your photos are clear, in focus, and well-lit. Avoid shadows and glare, and make sure all text and information is visible and legible.\n\nIf you encounter any issues during the verification process or have any questions, please don't hesitate to contact us for assistance.\n\n<a href='https://www.dataspike.io/contact-us'>Contact us</a>"
 	cancelText            = "Your verification process has been cancelled. If you need to verify your identity in the future, please don't hesitate to start the process again. If you encountered any issues or have any questions, please feel free to contact us for assistance.\n<a href='https://www.dataspike.io/contact-us'>Contact us</a>"
 	askExpertText         = "Hello and welcome!\n\nAs an AI expert in KYC, I am here to assist you with any questions you may have and help guide you through the KYC process. Whether you're new to KYC or a seasoned professional, I am here to provide you with the expertise and support you need to successfully complete your KYC requirements.\n\nPlease don't hesitate to ask me any questions you may have. I am always here to help and ensure your KYC experience is as smooth and hassle-free as possible."
+	expertResetText       = "I've forgotten our conversation. Feel free to ask a new question."
 	customizeBotText      = "Are you looking to integrate identity verification into your chatbot, but not sure where to start?\nAt Dataspike, we can help! Our KYC solution is designed to be easily integrated into any messaging platform, including Telegram, WhatsApp, and Facebook Messenger. \nAnd if you need a custom verification bot built to fit your unique requirements, we're happy to help with that too. \nWhether you're looking to streamline your customer onboarding process or improve security for your users, \nDataspike has the tools and expertise you need to get the job done. <a href='https://www.dataspike.io/contact-us'>Contact us</a> today to learn more!"
 	startVerificationInit = "To get started, we need to verify your identity. Please follow the prompts below to complete the process."
 	poiAttachDocument     = "Please attach photo of your document so we can verify its authenticity.\n\nYou can attach a Passport, residence permit, or national ID card. Provided document must have MRZ code."
@@ -27,14 +28,66 @@ Please click button below to open liveness widget.`
 	VerificationStartedPleaseWait = `Thank you!
 You have successfully uploaded all required documents`
 	AttachBackSideOfDoc = "Please attach <b>the back side</b> of your document so we can verify its authenticity."
-	SelectedPoaDocument = "Please attach poa document."
+	SelectedPoaDocument = "Please choose the type of your proof of address document."
 	VerificationOk      = "Congratulations! Your identity has been verified."
 	VerificationFailed  = `We're sorry, but your verification has failed. Please contact us for assistance to make new verification.
 
 <a href='https://www.dataspike.io/contact-us'>Contact us</a>`
-	LivenessFailed               = `We're sorry, but step with liveness photo has failed. Please check the reason below and try again.`
+	LivenessFailed            = `We're sorry, but step with liveness photo has failed. Please check the reason below and try again.`
+	livenessRetry             = "%s\n%s\n\nAttempts left: %d."
+	livenessNoReason          = "the photo didn't pass the liveness check"
+	livenessAttemptsExhausted = `We're sorry, but step with liveness photo has failed too many times. Please contact us for assistance to make new verification.
+
+<a href='https://www.dataspike.io/contact-us'>Contact us</a>`
 	verificationForBotIsDisabled = "Verification for bots is disabled."
 	verificationCompleted        = "Your verification is completed."
+	poaSkipped                   = "Proof of address step skipped."
+	poaSkipUnavailable           = "Pages of your proof of address have been uploaded, the step can't be skipped now."
+	buttonAlreadyPressed         = "This button has already been pressed."
+	buttonUnavailable            = "This button is no longer available."
+	unknownCommand               = "Oops, that command is new to me!"
+	poiFrontReceived             = "The front side of your document has been received."
+	poiBackInsteadOfFront        = "This looks like <b>the back side</b> of your document. Please attach <b>the front side</b> first."
+	poiFrontAgain                = "This looks like <b>the front side</b> again.\nPlease attach <b>the back side</b> of your document, or re-take the front side."
+	poiBothSidesReceived         = "Both sides of your document have been received."
+	retakeUnavailable            = "This side can't be re-taken anymore."
+	albumTooLarge                = "Please send no more than %d photo(s) at once for this step."
+	albumPartlyReceived          = "%d of the %d files you sent have been received."
+	albumSidesMismatch           = "We couldn't find both <b>the front side</b> and <b>the back side</b> of your document in these photos. Please attach them again."
+	poiChooseDocument            = "Which document would you like to verify your identity with?\nProvided document must have MRZ code."
+	poiChooseCountry             = "Which country has issued your document?"
+	poiDocumentChosen            = "Document: %s"
+	poiCountryChosen             = "Issuing country: %s"
+	poiCountrySkipped            = "Issuing country: not specified"
+	poiChooseFirst               = "Please choose your document with the buttons above before attaching it."
+	poiAttachChosen              = "Please attach a photo of <b>%s</b> of your %s so we can verify its authenticity.\n\n%s"
+	poiBackSideNext              = "\nWe will ask for <b>the back side</b> right after it, or you can attach both sides at once."
+	verificationUnderReview      = "All required documents have been uploaded and your verification is being reviewed."
+	poaCategoryChosen            = "Proof of address: %s"
+	poaPagesHint                 = "If the document has several pages, attach them one by one or all at once (up to %d pages), then press <b>Done</b>."
+	poaPageReceived              = "Page %d received. Attach the next page or press <b>Done</b> if there are no more pages."
+	poaPagesLimitReached         = "All %d pages have been received."
+	uploadRejectedText           = "We couldn't accept this file:\n%s\n\nPlease take a new photo and attach it again. Attempts left: %d."
+	uploadAttemptsExhausted      = "We still couldn't accept your document after several attempts.\nPlease contact our support team, we will help you to complete the verification."
+	poiAttachBackChosen          = "Please attach <b>the back side</b> of your %s so we can verify its authenticity.\n\n%s"
+	qualityRejectedText          = "We can't use this photo:\n%s\n\nPlease take a new photo and attach it again."
+	fileTooLargeText             = "The file is too large. Please send a file up to %s."
+	imageTooLargeText            = "The image is too large. Please send a photo of a smaller resolution."
+	imageUnreadableText          = "The image seems to be damaged. Please take a new photo and send it again."
+	fileTypeNotAllowedText       = "This type of file isn't accepted at this step. Please send a photo or a file in one of the formats: %s."
+	duplicatePhotoText           = "This looks like the same photo as %s. Please take a new photo for this step."
+	reminderIdle                 = "You haven't finished your verification yet. You stopped at: <b>%s</b>.\n\nPress <b>Continue</b> to pick up where you left off."
+	expertModeHint               = "Press <b>Exit expert mode</b> when you're done. Expert mode ends by itself after %s without questions."
+	expertModeExited             = "You've left expert mode. Send a message any time to get help with your verification, or use /ask_expert to ask the AI expert again."
+	expertModeTimedOut           = "Expert mode has ended as there were no questions for a while. Use /ask_expert to ask the AI expert again."
+	expertUnavailable            = "The AI expert isn't available at the moment. Use /help to learn more about the verification."
+	expertNoAnswer               = "Sorry, I couldn't come up with an answer. Please rephrase your question or ask another one."
+	expertAnswerBlocked          = "Sorry, I can't help with this topic. I can answer questions about identity verification, KYC and AML."
+	linkRemoved                  = "[link removed]"
+	knowledgeInstruction         = "Answer using the passages of the Dataspike knowledge base below when they are relevant, they take precedence over your general knowledge. Cite the passages you use by their numbers in square brackets, e.g. [1]. If the passages don't cover the question, say so rather than guessing Dataspike's requirements."
+	stepHelpText                 = "You're at: <b>%s</b>.\n%s\n\nPress <b>Continue</b> to see the instructions again, or use /ask_expert to ask the AI expert a question."
+	stepStatusText               = "Your verification is at: <b>%s</b>.\n\nUse /status to see the details, or /ask_expert to ask the AI expert a question."
+	reminderExpiry               = "Your verification expires in <b>%s</b>. You stopped at: <b>%s</b>.\n\nPress <b>Continue</b> to complete it in time."
 )
 
 const (
@@ -42,11 +95,26 @@ const (
 	Poi      = "poi"
 	Poa      = "poa"
 	Front    = "front"
+	Back     = "back"
 	learnMrz = "learn_more_about_mzr"
 	skipPoa  = "skip_poa"
 	pending  = "pending"
 	verified = "verified"
 
+	retakeFront = "retake_front"
+	retakeBack  = "retake_back"
+
+	// documentTypeData and issuedCountryData prefix the callback data of choice buttons, e.g. "doc_type:passport".
+	documentTypeData  = "doc_type"
+	issuedCountryData = "country"
+	poaCategoryData   = "poa_category"
+	poaDone           = "poa_done"
+
+	statusResume = "status_resume"
+	statusCancel = "status_cancel"
+
+	exitExpert = "exit_expert"
+
 	mrzLink = "https://static.dataspike.io/images/docver/mrz_sample.jpg"
 )
 
@@ -58,28 +126,13 @@ var contactUsKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 	),
 )
 
-var menu = tgbotapi.NewSetMyCommands(
-	tgbotapi.BotCommand{
-		Command:     "/help",
-		Description: "Help",
-	},
-	tgbotapi.BotCommand{
-		Command:     "/start_verification",
-		Description: "Start verification",
-	},
-	tgbotapi.BotCommand{
-		Command:     "/customize_bot",
-		Description: "Customize bot",
-	},
-	tgbotapi.BotCommand{
-		Command:     "/ask_expert",
-		Description: "Ask expert",
-	},
-	tgbotapi.BotCommand{
-		Command:     "/cancel",
-		Description: "Cancel",
-	},
-)
+func generateLivenessRetryKeyboard(url string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("Try again", url),
+		),
+	)
+}
 
 func generateLivenessKeyboard(url string) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
@@ -95,8 +148,40 @@ var mzrKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 	),
 )
 
+var emptyKeyboard = tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+
 var skipPoaKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 	tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("skip", skipPoa),
 	),
 )
+
+var retakeFrontKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Re-take front side", retakeFront),
+	),
+)
+
+var retakeBackKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Re-take back side", retakeBack),
+	),
+)
+
+var reminderKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Continue", statusResume),
+	),
+)
+
+var exitExpertKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Exit expert mode", exitExpert),
+	),
+)
+
+var poaDoneKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Done", poaDone),
+	),
+)
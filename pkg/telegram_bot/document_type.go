@@ -0,0 +1,196 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// documentKind is an identity document the user can choose before uploading it.
+type documentKind struct {
+	// id is the document type as named in the dataspike verification settings.
+	id string
+	// title is shown on the button, label in the prompts.
+	title string
+	label string
+	// page is the part of the document requested first.
+	page     string
+	twoSided bool
+	// mrzHint tells the user where to find the MRZ on the document.
+	mrzHint string
+}
+
+var documentKinds = []documentKind{
+	{
+		id:      "passport",
+		title:   "Passport",
+		label:   "passport",
+		page:    "the photo page",
+		mrzHint: "The MRZ is the two lines of characters at the bottom of the photo page.",
+	},
+	{
+		id:       "id_card",
+		title:    "National ID card",
+		label:    "national ID card",
+		page:     "the front side",
+		twoSided: true,
+		mrzHint:  "The MRZ is the three lines of characters at the bottom of the back side.",
+	},
+	{
+		id:       "residence_permit",
+		title:    "Residence permit",
+		label:    "residence permit",
+		page:     "the front side",
+		twoSided: true,
+		mrzHint:  "The MRZ is the lines of characters at the bottom of the back side.",
+	},
+}
+
+func documentKindByID(id string) (documentKind, bool) {
+	for _, kind := range documentKinds {
+		if kind.id == id {
+			return kind, true
+		}
+	}
+
+	return documentKind{}, false
+}
+
+// allowedDocumentKinds lists the documents accepted by the verification settings.
+// All known documents are offered when the settings don't restrict them or none of them is known.
+func allowedDocumentKinds(s *flow.Session) []documentKind {
+	if s.Verification.Settings == nil || len(s.Verification.Settings.PoiAllowedDocuments) == 0 {
+		return documentKinds
+	}
+
+	var kinds []documentKind
+	for _, id := range s.Verification.Settings.PoiAllowedDocuments {
+		if kind, ok := documentKindByID(id); ok {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return documentKinds
+	}
+
+	return kinds
+}
+
+// documentTwoSided reports whether the user has chosen a document with two sides.
+func documentTwoSided(s *flow.Session) bool {
+	kind, ok := documentKindByID(s.DocumentType)
+	return ok && kind.twoSided
+}
+
+func documentTypeKeyboard(kinds []documentKind) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(kinds))
+	for _, kind := range kinds {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(kind.title, documentTypeData+":"+kind.id),
+		))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// countryKeyboard offers the countries allowed by the verification, three in a row, and a button to skip the choice.
+func countryKeyboard(countries []string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(countries); i += 3 {
+		row := tgbotapi.NewInlineKeyboardRow()
+		for _, country := range countries[i:min(i+3, len(countries))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(country, issuedCountryData+":"+country))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Skip", issuedCountryData+":"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (t *TelegramBot) promptDocumentType(chatID int64, s *flow.Session) error {
+	return t.sendHTML(chatID, poiChooseDocument, documentTypeKeyboard(allowedDocumentKinds(s)))
+}
+
+func (t *TelegramBot) promptCountry(chatID int64, s *flow.Session) error {
+	return t.sendHTML(chatID, poiChooseCountry, countryKeyboard(s.Verification.Settings.Countries))
+}
+
+// documentTypeCallback stores the document chosen by the user and moves on to the issuing country or the upload.
+func (t *TelegramBot) documentTypeCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery, id string) error {
+	kind, ok := documentKindByID(id)
+	if !ok {
+		err := t.answerCallback(callbackQuery, buttonUnavailable)
+		if err != nil {
+			return err
+		}
+		return errors.New("undefined document type")
+	}
+
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, fmt.Sprintf(poiDocumentChosen, kind.label))
+	if err != nil {
+		return err
+	}
+
+	tgID := strconv.FormatInt(callbackQuery.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+
+	session.DocumentType = kind.id
+	if settings := session.Verification.Settings; settings != nil && len(settings.Countries) == 1 {
+		session.IssuedCountry = settings.Countries[0]
+	}
+
+	return t.choiceMade(ctx, callbackQuery.From.ID, session, flow.EventDocumentSelected)
+}
+
+// countryCallback stores the issuing country chosen by the user. An empty country skips the choice.
+func (t *TelegramBot) countryCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery, country string) error {
+	text := poiCountrySkipped
+	if country != "" {
+		text = fmt.Sprintf(poiCountryChosen, country)
+	}
+
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, text)
+	if err != nil {
+		return err
+	}
+
+	tgID := strconv.FormatInt(callbackQuery.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+
+	session.IssuedCountry = country
+	return t.choiceMade(ctx, callbackQuery.From.ID, session, flow.EventCountrySelected)
+}
+
+func (t *TelegramBot) choiceMade(ctx context.Context, chatID int64, session *flow.Session, event flow.Event) error {
+	err := t.fire(ctx, strconv.FormatInt(chatID, 10), session, event)
+	if errors.Is(err, flow.ErrNotAllowed) {
+		_, err = t.send(tgbotapi.NewMessage(chatID, buttonUnavailable))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	return t.nextCheck(chatID, session)
+}
@@ -0,0 +1,114 @@
+package telegram_bot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultDownloadAttempts = 3
+	defaultDownloadTimeout  = 30 * time.Second
+	// downloadBackoff is the delay before the second attempt, it doubles with every next one.
+	downloadBackoff = 500 * time.Millisecond
+)
+
+// downloadedFile is the content of the file received from telegram.
+type downloadedFile struct {
+	data []byte
+	// sha256 is the hex encoded checksum of the data.
+	sha256 string
+}
+
+// downloadStatusError is returned when telegram responds to the download with an unexpected status.
+type downloadStatusError struct {
+	status int
+}
+
+func (e *downloadStatusError) Error() string {
+	return fmt.Sprintf("download failed with status %d %s", e.status, http.StatusText(e.status))
+}
+
+// transient reports whether another attempt may succeed.
+func (e *downloadStatusError) transient() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= http.StatusInternalServerError
+}
+
+// download fetches the file from telegram. Every attempt has its own timeout, network errors and
+// transient statuses are retried with a growing delay until the attempts run out or the context is done.
+func (t *TelegramBot) download(ctx context.Context, url string) (*downloadedFile, error) {
+	attempts := t.downloadAttempts
+	if attempts <= 0 {
+		attempts = defaultDownloadAttempts
+	}
+	backoff := t.downloadBackoff
+	if backoff == 0 {
+		backoff = downloadBackoff
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var file *downloadedFile
+		file, err = t.downloadAttempt(ctx, url)
+		if err == nil {
+			return file, nil
+		}
+
+		var status *downloadStatusError
+		var refused *refusedFileError
+		retry := !errors.As(err, &refused) && (!errors.As(err, &status) || status.transient())
+		if !retry || attempt >= attempts || ctx.Err() != nil {
+			return nil, err
+		}
+
+		t.log().Warn("download failed, retrying", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (t *TelegramBot) downloadAttempt(ctx context.Context, url string) (*downloadedFile, error) {
+	timeout := t.downloadTimeout
+	if timeout <= 0 {
+		timeout = defaultDownloadTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		// the URL of the file contains the bot token
+		return nil, t.redactor.Error(err)
+	}
+	defer resp.Body.Close()
+
+	// an error page must never be taken for the document
+	if resp.StatusCode != http.StatusOK {
+		return nil, &downloadStatusError{status: resp.StatusCode}
+	}
+
+	// one byte more than allowed tells a file of the maximum size from a larger one
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(t.maxSize())+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > t.maxSize() {
+		return nil, &refusedFileError{reason: fmt.Sprintf(fileTooLargeText, formatSize(t.maxSize()))}
+	}
+
+	sum := sha256.Sum256(data)
+	return &downloadedFile{data: data, sha256: hex.EncodeToString(sum[:])}, nil
+}
@@ -0,0 +1,215 @@
+package telegram_bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/conversation"
+	"github.com/dataspike-io/docver-tg-bot/pkg/knowledge"
+	"github.com/dataspike-io/docver-tg-bot/pkg/redact"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultExpertTimeout = 10 * time.Minute
+
+// expertModes remembers the users talking to the expert and when their expert mode ends.
+// The zero value is ready to use.
+type expertModes struct {
+	mu    sync.Mutex
+	until map[int64]time.Time
+}
+
+func (m *expertModes) enter(chatID int64, until time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.until == nil {
+		m.until = make(map[int64]time.Time)
+	}
+	m.until[chatID] = until
+}
+
+func (m *expertModes) leave(chatID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.until, chatID)
+}
+
+// touch reports whether the user is in expert mode and prolongs it by the timeout. Expired reports
+// the mode which has just ended, so the user is told about it once.
+func (m *expertModes) touch(chatID int64, now time.Time, timeout time.Duration) (active, expired bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	until, ok := m.until[chatID]
+	if !ok {
+		return false, false
+	}
+	if now.After(until) {
+		delete(m.until, chatID)
+		return false, true
+	}
+	m.until[chatID] = now.Add(timeout)
+
+	return true, false
+}
+
+// llmRedactions counts the personal data removed from the questions to the LLM, by kind.
+var llmRedactions = expvar.NewMap("llm_pii_redactions")
+
+// redactPII replaces the personal data in the question before it leaves for the LLM. The question
+// is kept in the conversation history redacted as well.
+func (t *TelegramBot) redactPII(tgID string, question string) string {
+	redacted, counts := redact.PII(question)
+	if len(counts) == 0 {
+		return question
+	}
+
+	for kind, n := range counts {
+		llmRedactions.Add(string(kind), int64(n))
+	}
+	t.log().Info("personal data redacted", "tg_id", tgID, "redactions", counts)
+
+	return redacted
+}
+
+// askExpert answers the question with the LLM. The answer is checked against the output policy before
+// it is sent, and split into several messages when it is too long for one.
+func (t *TelegramBot) askExpert(ctx context.Context, message *tgbotapi.Message) error {
+	tgID := strconv.FormatInt(message.From.ID, 10)
+	question := t.redactPII(tgID, message.Text)
+	messages, passages := t.expertMessages(ctx, tgID, question)
+	answer, err := t.llm.Complete(ctx, messages)
+	if err != nil {
+		return err
+	}
+
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		t.log().Warn("expert answer is empty", "tg_id", tgID)
+		return t.sendHTML(message.From.ID, expertNoAnswer, exitExpertKeyboard)
+	}
+	filter := t.outputFilter()
+	if topic, blocked := filter.blockedTopic(answer); blocked {
+		t.log().Warn("expert answer blocked", "tg_id", tgID, "topic", topic)
+		return t.sendHTML(message.From.ID, expertAnswerBlocked, exitExpertKeyboard)
+	}
+	answer = filter.filterLinks(answer)
+	t.rememberExchange(ctx, tgID, question, answer)
+
+	parts := splitAnswer(answer+citedSources(answer, passages), maxMessageLength)
+	for i, part := range parts {
+		msg := tgbotapi.NewMessage(message.From.ID, formatHTML(part))
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.DisableWebPagePreview = true
+		if i == len(parts)-1 {
+			msg.ReplyMarkup = exitExpertKeyboard
+		}
+		_, err = t.send(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *TelegramBot) outputFilter() *outputFilter {
+	if t.output == nil {
+		return defaultOutputFilter
+	}
+
+	return t.output
+}
+
+// exitExpertCallback leaves expert mode, the conversation is kept for the next time.
+func (t *TelegramBot) exitExpertCallback(callbackQuery *tgbotapi.CallbackQuery) error {
+	t.expert.leave(callbackQuery.From.ID)
+
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = t.send(tgbotapi.NewMessage(callbackQuery.From.ID, expertModeExited))
+	return err
+}
+
+// stepHelp tells the user what to do in the current step of the verification, or how to start one.
+func (t *TelegramBot) stepHelp(ctx context.Context, chatID int64) error {
+	session, err := t.cache.GetSession(ctx, strconv.FormatInt(chatID, 10))
+	if err != nil {
+		// there is no verification in progress
+		return t.sendHTML(chatID, helpText, nil)
+	}
+
+	st := stepFor(session.State)
+	if !resumable(session.State) || st.help == "" {
+		return t.sendHTML(chatID, fmt.Sprintf(stepStatusText, st.title), nil)
+	}
+
+	return t.sendHTML(chatID, fmt.Sprintf(stepHelpText, st.title, st.help), reminderKeyboard)
+}
+
+// expertMessages builds the conversation for the LLM: the prompt, the knowledge base passages relevant
+// to the question, the recent history of the user and the question. The history is only a nicety,
+// the question is answered without it when it can't be loaded.
+func (t *TelegramBot) expertMessages(ctx context.Context, tgID string, question string) ([]ChatMessage, []knowledge.Result) {
+	var history []conversation.Turn
+	if t.expertMemory != nil {
+		var err error
+		history, err = t.expertMemory.History(ctx, tgID)
+		if err != nil {
+			t.log().Warn("expert history loading failed", "tg_id", tgID, "error", err)
+		}
+	}
+
+	messages := []ChatMessage{{Role: RoleSystem, Content: t.prompt}}
+	passages := t.retrieve(history, question)
+	if len(passages) > 0 {
+		messages = append(messages, ChatMessage{Role: RoleSystem, Content: knowledgeContext(passages)})
+	}
+	for _, turn := range history {
+		messages = append(messages, ChatMessage{Role: Role(turn.Role), Content: turn.Content})
+	}
+
+	return append(messages, ChatMessage{Role: RoleUser, Content: question}), passages
+}
+
+// rememberExchange adds the question and the answer to the history of the user.
+func (t *TelegramBot) rememberExchange(ctx context.Context, tgID string, question, answer string) {
+	if t.expertMemory == nil {
+		return
+	}
+
+	err := t.expertMemory.Remember(ctx, tgID,
+		conversation.Turn{Role: conversation.RoleUser, Content: question},
+		conversation.Turn{Role: conversation.RoleAssistant, Content: answer},
+	)
+	if err != nil {
+		t.log().Warn("expert history saving failed", "tg_id", tgID, "error", err)
+	}
+}
+
+// resetExpertCommand forgets the conversation with the expert, so the next question starts a new one.
+func (t *TelegramBot) resetExpertCommand(ctx context.Context, message *tgbotapi.Message) error {
+	if t.expertMemory != nil {
+		err := t.expertMemory.Reset(ctx, strconv.FormatInt(message.From.ID, 10))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := t.send(tgbotapi.NewMessage(message.From.ID, expertResetText))
+	return err
+}
@@ -0,0 +1,181 @@
+package telegram_bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+)
+
+// defaultMaxFileSize is the largest file the Bot API lets bots download.
+const defaultMaxFileSize = 20 << 20
+
+// maxFilenameLength limits the length of the sanitized filename, extension included.
+const maxFilenameLength = 64
+
+const (
+	mimeJPEG = "image/jpeg"
+	mimePNG  = "image/png"
+	mimeGIF  = "image/gif"
+	mimeWebP = "image/webp"
+	mimeTIFF = "image/tiff"
+	mimePDF  = "application/pdf"
+)
+
+// imageTypes are the formats photos are accepted in.
+var imageTypes = []string{mimeJPEG, mimePNG, mimeGIF, mimeWebP, mimeTIFF}
+
+// fileTypes describe the accepted formats to the user and name the files uploaded to dataspike.
+var fileTypes = map[string]struct{ name, ext string }{
+	mimeJPEG: {"JPEG", ".jpg"},
+	mimePNG:  {"PNG", ".png"},
+	mimeGIF:  {"GIF", ".gif"},
+	mimeWebP: {"WebP", ".webp"},
+	mimeTIFF: {"TIFF", ".tiff"},
+	mimePDF:  {"PDF", ".pdf"},
+}
+
+// refusedFileError is returned when the file isn't accepted in the step and hasn't been uploaded to dataspike.
+type refusedFileError struct {
+	reason string
+}
+
+func (e *refusedFileError) Error() string {
+	return e.reason
+}
+
+// allowedTypes are the formats accepted in the step: photos, and PDF documents for the proof of address.
+func (t *TelegramBot) allowedTypes(state flow.State) []string {
+	if types, ok := t.fileTypes[state]; ok {
+		return types
+	}
+	if state == flow.StatePoa {
+		return append(imageTypes[:len(imageTypes):len(imageTypes)], mimePDF)
+	}
+
+	return imageTypes
+}
+
+func (t *TelegramBot) maxSize() int {
+	if t.maxFileSize > 0 {
+		return t.maxFileSize
+	}
+
+	return defaultMaxFileSize
+}
+
+// checkFileSize refuses the file before it's downloaded when telegram reports it larger than allowed.
+func (t *TelegramBot) checkFileSize(size int) error {
+	if size > t.maxSize() {
+		return &refusedFileError{reason: fmt.Sprintf(fileTooLargeText, formatSize(t.maxSize()))}
+	}
+
+	return nil
+}
+
+// checkFileType sniffs the content of the file, refuses formats not accepted in the step and returns
+// a safe filename with the extension of the detected format.
+func (t *TelegramBot) checkFileType(state flow.State, filename string, data []byte) (string, error) {
+	mime := sniffType(data)
+	allowed := t.allowedTypes(state)
+	for _, a := range allowed {
+		if a == mime {
+			return sanitizeFilename(filename, fileTypes[mime].ext), nil
+		}
+	}
+
+	names := make([]string, 0, len(allowed))
+	for _, a := range allowed {
+		if ft, ok := fileTypes[a]; ok {
+			names = append(names, ft.name)
+		} else {
+			names = append(names, a)
+		}
+	}
+
+	return "", &refusedFileError{reason: fmt.Sprintf(fileTypeNotAllowedText, strings.Join(names, ", "))}
+}
+
+func formatSize(size int) string {
+	if size >= 1<<20 {
+		return fmt.Sprintf("%d MB", size>>20)
+	}
+
+	return fmt.Sprintf("%d KB", size>>10)
+}
+
+// sniffType detects the MIME type of the content, the name and the type claimed by the user are never trusted.
+func sniffType(data []byte) string {
+	// http.DetectContentType doesn't know TIFF
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return mimeTIFF
+	}
+
+	mime, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return mime
+}
+
+// sanitizeFilename keeps the base name of the file with only letters, digits, dashes and underscores,
+// and replaces the extension with the given one.
+func sanitizeFilename(filename, ext string) string {
+	base := path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	base = strings.TrimSuffix(base, path.Ext(base))
+
+	var b strings.Builder
+	for _, r := range base {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteRune('_')
+		}
+	}
+
+	name := strings.TrimRight(b.String(), "_")
+	if len(name) > maxFilenameLength-len(ext) {
+		name = name[:maxFilenameLength-len(ext)]
+	}
+	if name == "" {
+		name = "document"
+	}
+
+	return name + ext
+}
+
+// fileRefused keeps the step open and tells the user which files are accepted.
+func (t *TelegramBot) fileRefused(chatID int64, session *flow.Session, refused *refusedFileError) error {
+	t.log().Info("file refused",
+		"tg_id", strconv.FormatInt(chatID, 10),
+		"verification_id", session.Verification.Id,
+		"state", session.State,
+		"reason", refused.Error(),
+	)
+
+	return t.sendHTML(chatID, refused.reason, nil)
+}
+
+// uploadFailed replies to the errors the user can fix by sending another file, other errors are returned as is.
+func (t *TelegramBot) uploadFailed(ctx context.Context, chatID int64, session *flow.Session, err error) error {
+	var rejected *rejectedUploadError
+	var poor *poorQualityError
+	var refused *refusedFileError
+	var duplicate *duplicatePhotoError
+	switch {
+	case errors.As(err, &rejected):
+		return t.uploadRejected(ctx, chatID, session, rejected)
+	case errors.As(err, &poor):
+		return t.qualityRejected(chatID, session, poor)
+	case errors.As(err, &refused):
+		return t.fileRefused(chatID, session, refused)
+	case errors.As(err, &duplicate):
+		return t.duplicateRejected(chatID, session, duplicate)
+	}
+
+	return err
+}
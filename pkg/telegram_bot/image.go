@@ -0,0 +1,126 @@
+package telegram_bot
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	"github.com/dataspike-io/docver-tg-bot/pkg/imaging"
+)
+
+// duplicateSubjects name what the photo uploaded in the step shows.
+var duplicateSubjects = map[flow.State]string{
+	flow.StatePoiFront: "your identity document",
+	flow.StatePoiBack:  "the back side of your identity document",
+	flow.StateSelfie:   "your selfie",
+	flow.StatePoa:      "your proof of address",
+}
+
+// preparedFile is the file ready to be uploaded to dataspike.
+type preparedFile struct {
+	filename string
+	data     []byte
+	// phash is the perceptual hash of the photo, zero for other files.
+	phash imaging.Hash
+	// sha256 is the checksum of the file received from the user.
+	sha256 string
+}
+
+// duplicatePhotoError is returned when the photo looks the same as one uploaded in another step.
+type duplicatePhotoError struct {
+	of flow.State
+}
+
+func (e *duplicatePhotoError) Error() string {
+	return fmt.Sprintf("the photo duplicates the one uploaded in %s", e.of)
+}
+
+// prepareImage checks the photo and normalizes it before the upload: the photo is turned upright,
+// stripped of its metadata, e.g. GPS coordinates, downscaled and converted to JPEG. Files which aren't
+// images, e.g. PDF documents, are uploaded as is for dataspike to check. Images which can't be decoded
+// are refused, as their metadata can't be stripped.
+func (t *TelegramBot) prepareImage(session *flow.Session, filename string, data []byte) (*preparedFile, error) {
+	if !slices.Contains(imageTypes, sniffType(data)) {
+		return &preparedFile{filename: filename, data: data}, nil
+	}
+
+	img, err := imaging.Decode(data)
+	if errors.Is(err, imaging.ErrTooLarge) {
+		return nil, &refusedFileError{reason: imageTooLargeText}
+	}
+	if err != nil {
+		return nil, &refusedFileError{reason: imageUnreadableText}
+	}
+
+	err = t.checkQuality(session.State, img)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := imaging.DHash(img)
+	if of, ok := duplicateOf(session, hash); ok {
+		return nil, &duplicatePhotoError{of: of}
+	}
+
+	normalized, err := imaging.EncodeJPEG(img, t.maxImageEdge)
+	if err != nil {
+		return nil, err
+	}
+
+	return &preparedFile{
+		filename: strings.TrimSuffix(filename, path.Ext(filename)) + ".jpg",
+		data:     normalized,
+		phash:    hash,
+	}, nil
+}
+
+// duplicateOf finds the step a photo looking the same has been uploaded in. Photos of the current step
+// aren't compared, the user may retake the same shot or send similar pages of a document.
+func duplicateOf(session *flow.Session, hash imaging.Hash) (flow.State, bool) {
+	for _, u := range session.Uploads {
+		if u.PHash == 0 || u.State == session.State {
+			continue
+		}
+		if hash.Similar(imaging.Hash(u.PHash)) {
+			return u.State, true
+		}
+	}
+
+	return "", false
+}
+
+// duplicateInAlbum reports whether the file is the same as one sent before it in the album. The sides of
+// a document are compared as photos of different steps would be, the pages of a document only as files.
+func duplicateInAlbum(files []*preparedFile, file *preparedFile, docType string) bool {
+	for _, f := range files {
+		if f.sha256 == file.sha256 {
+			return true
+		}
+		if docType == Poi && f.phash != 0 && file.phash != 0 && file.phash.Similar(f.phash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// duplicateRejected keeps the step open and tells the user which photo has been sent again.
+func (t *TelegramBot) duplicateRejected(chatID int64, session *flow.Session, duplicate *duplicatePhotoError) error {
+	t.log().Info("duplicate photo",
+		"tg_id", strconv.FormatInt(chatID, 10),
+		"verification_id", session.Verification.Id,
+		"state", session.State,
+		"duplicate_of", duplicate.of,
+	)
+
+	subject, ok := duplicateSubjects[duplicate.of]
+	if !ok {
+		subject = "one you have already sent"
+	}
+
+	return t.sendHTML(chatID, fmt.Sprintf(duplicatePhotoText, subject), nil)
+}
@@ -0,0 +1,78 @@
+package telegram_bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/conversation"
+	"github.com/dataspike-io/docver-tg-bot/pkg/knowledge"
+)
+
+const defaultKnowledgeLimit = 3
+
+// KnowledgeBase is the type needed for the bot to find the passages the expert answers from, e.g. knowledge.Index.
+type KnowledgeBase interface {
+	Search(query string, limit int) []knowledge.Result
+}
+
+// retrieve finds the passages for the question. Follow-up questions, e.g. "and what about Germany?",
+// rarely name the subject, so the limit is topped up with the passages found along with the previous
+// question of the user.
+func (t *TelegramBot) retrieve(history []conversation.Turn, question string) []knowledge.Result {
+	if t.knowledge == nil {
+		return nil
+	}
+
+	passages := t.knowledge.Search(question, t.knowledgeLimit)
+	for i := len(history) - 1; i >= 0 && len(passages) < t.knowledgeLimit; i-- {
+		if history[i].Role != conversation.RoleUser {
+			continue
+		}
+		for _, p := range t.knowledge.Search(history[i].Content+"\n"+question, t.knowledgeLimit) {
+			if len(passages) < t.knowledgeLimit && !containsPassage(passages, p.Passage.ID) {
+				passages = append(passages, p)
+			}
+		}
+		break
+	}
+
+	return passages
+}
+
+func containsPassage(passages []knowledge.Result, id string) bool {
+	for _, p := range passages {
+		if p.Passage.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// knowledgeContext instructs the LLM to answer from the numbered passages and to cite them.
+func knowledgeContext(passages []knowledge.Result) string {
+	var b strings.Builder
+	b.WriteString(knowledgeInstruction)
+	for i, p := range passages {
+		fmt.Fprintf(&b, "\n\n[%d] %s (%s)\n%s", i+1, p.Passage.Title, p.Passage.Source, p.Passage.Text)
+	}
+
+	return b.String()
+}
+
+// citedSources lists the passages the answer cites, so the user can tell where the answer comes from.
+func citedSources(answer string, passages []knowledge.Result) string {
+	var b strings.Builder
+	for i, p := range passages {
+		citation := fmt.Sprintf("[%d]", i+1)
+		if !strings.Contains(answer, citation) {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("\n\nSources:")
+		}
+		fmt.Fprintf(&b, "\n%s %s, %s", citation, p.Passage.Title, p.Passage.Source)
+	}
+
+	return b.String()
+}
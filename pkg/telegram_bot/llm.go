@@ -0,0 +1,163 @@
+package telegram_bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultOpenAIURL is the base URL of the OpenAI API, other vendors and self-hosted models
+	// serving the same API have their own.
+	DefaultOpenAIURL = "https://api.openai.com/v1"
+	DefaultLLMModel  = "gpt-3.5-turbo"
+	// maxLLMResponseSize limits the response read from the LLM API.
+	maxLLMResponseSize = 1 << 20
+)
+
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// ChatMessage is a message of the conversation with the LLM.
+type ChatMessage struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// LLM is the type needed for the bot to answer text messages.
+type LLM interface {
+	// Complete returns the answer to the conversation.
+	Complete(ctx context.Context, messages []ChatMessage) (string, error)
+}
+
+// OpenAI is the LLM served over the OpenAI chat completions API.
+type OpenAI struct {
+	client  IHTTPClient
+	baseURL string
+	model   string
+	apiKey  string
+}
+
+// NewOpenAI creates the client of the OpenAI compatible API at the base URL, e.g. https://api.openai.com/v1.
+// Empty base URL and model fall back to DefaultOpenAIURL and DefaultLLMModel, nil client to http.DefaultClient.
+// Self-hosted models usually need no API key.
+func NewOpenAI(client IHTTPClient, baseURL, model, apiKey string) *OpenAI {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if baseURL == "" {
+		baseURL = DefaultOpenAIURL
+	}
+	if model == "" {
+		model = DefaultLLMModel
+	}
+
+	return &OpenAI{client: client, baseURL: strings.TrimSuffix(baseURL, "/"), model: model, apiKey: apiKey}
+}
+
+type completionRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+type completionResponse struct {
+	Choices []struct {
+		Message ChatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *OpenAI) Complete(ctx context.Context, messages []ChatMessage) (string, error) {
+	body, err := json.Marshal(completionRequest{Model: o.model, Messages: messages})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLLMResponseSize))
+	if err != nil {
+		return "", err
+	}
+
+	var completion completionResponse
+	decodeErr := json.Unmarshal(data, &completion)
+	if resp.StatusCode != http.StatusOK {
+		if decodeErr == nil && completion.Error != nil {
+			return "", fmt.Errorf("llm request failed with status %d: %s", resp.StatusCode, completion.Error.Message)
+		}
+		return "", fmt.Errorf("llm request failed with status %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return "", decodeErr
+	}
+	if len(completion.Choices) == 0 {
+		// nothing to answer with, same as an empty answer
+		return "", nil
+	}
+
+	return completion.Choices[0].Message.Content, nil
+}
+
+// StubLLM is the deterministic LLM for tests and local runs: it answers with Reply, or echoes
+// the last user message when Reply is empty, and fails with Err when it is set.
+type StubLLM struct {
+	Reply string
+	Err   error
+
+	mu       sync.Mutex
+	requests [][]ChatMessage
+}
+
+func (s *StubLLM) Complete(_ context.Context, messages []ChatMessage) (string, error) {
+	s.mu.Lock()
+	s.requests = append(s.requests, append([]ChatMessage(nil), messages...))
+	s.mu.Unlock()
+
+	if s.Err != nil {
+		return "", s.Err
+	}
+	if s.Reply != "" {
+		return s.Reply, nil
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			return messages[i].Content, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Requests returns the conversations the stub has been asked to complete.
+func (s *StubLLM) Requests() [][]ChatMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]ChatMessage(nil), s.requests...)
+}
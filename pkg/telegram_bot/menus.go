@@ -0,0 +1,93 @@
+package telegram_bot
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MenuScope is a chat, or every chat when ChatID is zero, and a language the command menu is published for.
+type MenuScope struct {
+	ChatID   int64  `json:"chat_id,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+func (s MenuScope) botScope() tgbotapi.BotCommandScope {
+	if s.ChatID == 0 {
+		return tgbotapi.NewBotCommandScopeDefault()
+	}
+
+	return tgbotapi.NewBotCommandScopeChat(s.ChatID)
+}
+
+// IMenuStore is the type needed for the bot to remember the published command menus, so the menus
+// of removed admins and languages are deleted on the next start.
+type IMenuStore interface {
+	LoadMenus() ([]MenuScope, error)
+	SaveMenus(scopes []MenuScope) error
+}
+
+// MenuFileStore keeps the published command menus in a JSON file.
+type MenuFileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewMenuFileStore creates the store of the file at the path. A missing file is created on the first save.
+func NewMenuFileStore(path string) *MenuFileStore {
+	return &MenuFileStore{path: path}
+}
+
+func (f *MenuFileStore) LoadMenus() ([]MenuScope, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []MenuScope
+	err = json.Unmarshal(data, &scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return scopes, nil
+}
+
+// SaveMenus replaces the file atomically, so a crash never leaves it half written.
+func (f *MenuFileStore) SaveMenus(scopes []MenuScope) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.Marshal(scopes)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -10,6 +10,7 @@ import (
 	reflect "reflect"
 
 	dataspike "github.com/dataspike-io/docver-sdk-go"
+	flow "github.com/dataspike-io/docver-tg-bot/pkg/flow"
 	uuid "github.com/gofrs/uuid"
 	gomock "github.com/golang/mock/gomock"
 )
@@ -75,47 +76,47 @@ func (m *MockICache) EXPECT() *MockICacheMockRecorder {
 	return m.recorder
 }
 
-// GetVerification mocks base method.
-func (m *MockICache) GetVerification(arg0 context.Context, arg1 string) (*dataspike.Verification, error) {
+// GetSession mocks base method.
+func (m *MockICache) GetSession(arg0 context.Context, arg1 string) (*flow.Session, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetVerification", arg0, arg1)
-	ret0, _ := ret[0].(*dataspike.Verification)
+	ret := m.ctrl.Call(m, "GetSession", arg0, arg1)
+	ret0, _ := ret[0].(*flow.Session)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetVerification indicates an expected call of GetVerification.
-func (mr *MockICacheMockRecorder) GetVerification(arg0, arg1 interface{}) *gomock.Call {
+// GetSession indicates an expected call of GetSession.
+func (mr *MockICacheMockRecorder) GetSession(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVerification", reflect.TypeOf((*MockICache)(nil).GetVerification), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSession", reflect.TypeOf((*MockICache)(nil).GetSession), arg0, arg1)
 }
 
-// RemoveVerification mocks base method.
-func (m *MockICache) RemoveVerification(arg0 context.Context, arg1 string) error {
+// RemoveSession mocks base method.
+func (m *MockICache) RemoveSession(arg0 context.Context, arg1 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RemoveVerification", arg0, arg1)
+	ret := m.ctrl.Call(m, "RemoveSession", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// RemoveVerification indicates an expected call of RemoveVerification.
-func (mr *MockICacheMockRecorder) RemoveVerification(arg0, arg1 interface{}) *gomock.Call {
+// RemoveSession indicates an expected call of RemoveSession.
+func (mr *MockICacheMockRecorder) RemoveSession(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveVerification", reflect.TypeOf((*MockICache)(nil).RemoveVerification), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveSession", reflect.TypeOf((*MockICache)(nil).RemoveSession), arg0, arg1)
 }
 
-// SetVerification mocks base method.
-func (m *MockICache) SetVerification(arg0 context.Context, arg1 string, arg2 *dataspike.Verification) error {
+// SetSession mocks base method.
+func (m *MockICache) SetSession(arg0 context.Context, arg1 string, arg2 *flow.Session) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetVerification", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "SetSession", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// SetVerification indicates an expected call of SetVerification.
-func (mr *MockICacheMockRecorder) SetVerification(arg0, arg1, arg2 interface{}) *gomock.Call {
+// SetSession indicates an expected call of SetSession.
+func (mr *MockICacheMockRecorder) SetSession(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVerification", reflect.TypeOf((*MockICache)(nil).SetVerification), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSession", reflect.TypeOf((*MockICache)(nil).SetSession), arg0, arg1, arg2)
 }
 
 // MockIDataspikeClient is a mock of IDataspikeClient interface.
@@ -355,17 +356,17 @@ func (m *MockITelegramBot) EXPECT() *MockITelegramBotMockRecorder {
 }
 
 // CheckLiveness mocks base method.
-func (m *MockITelegramBot) CheckLiveness(ctx context.Context, applicantId, status string) error {
+func (m *MockITelegramBot) CheckLiveness(ctx context.Context, applicantId, status string, reasons dataspike.Errors) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CheckLiveness", ctx, applicantId, status)
+	ret := m.ctrl.Call(m, "CheckLiveness", ctx, applicantId, status, reasons)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CheckLiveness indicates an expected call of CheckLiveness.
-func (mr *MockITelegramBotMockRecorder) CheckLiveness(ctx, applicantId, status interface{}) *gomock.Call {
+func (mr *MockITelegramBotMockRecorder) CheckLiveness(ctx, applicantId, status, reasons interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckLiveness", reflect.TypeOf((*MockITelegramBot)(nil).CheckLiveness), ctx, applicantId, status)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckLiveness", reflect.TypeOf((*MockITelegramBot)(nil).CheckLiveness), ctx, applicantId, status, reasons)
 }
 
 // SendVerificationStatus mocks base method.
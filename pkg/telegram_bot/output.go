@@ -0,0 +1,215 @@
+package telegram_bot
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxMessageLength is the longest text telegram accepts in a message.
+const maxMessageLength = 4096
+
+// codeFence starts and ends the code blocks in markdown.
+const codeFence = "```"
+
+// linkTrailing is the punctuation ending a sentence rather than the link before it.
+const linkTrailing = ".,;:!?"
+
+// OutputPolicy limits what the answers of the LLM may contain.
+type OutputPolicy struct {
+	// BlockedTopics are words or phrases, e.g. "weapons". Answers mentioning them aren't sent.
+	BlockedTopics []string
+	// AllowedDomains are the domains, along with their subdomains, answers may link to. Other links are removed.
+	AllowedDomains []string
+}
+
+var defaultOutputPolicy = OutputPolicy{AllowedDomains: []string{"dataspike.io"}}
+
+var defaultOutputFilter = newOutputFilter(defaultOutputPolicy)
+
+var (
+	markdownLink = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+	// link matches URLs and the bare domains telegram turns into links, e.g. "example.com/page".
+	link        = regexp.MustCompile(`(?i)\b(?:https?://[^\s<>"']+|(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+(?:com|net|org|io|info|biz|app|dev|ai|co|me|xyz|ru|de|uk|eu|us|fr|es|it|nl|ch|tv|ly|gg|to|cc)\b(?:/[^\s<>"']*)?)`)
+	codeSpan    = regexp.MustCompile("(?s)```[a-z]*\n?(.*?)```|`([^`\n]+)`")
+	codeRef     = regexp.MustCompile("\x00([0-9]+)\x00")
+	bold        = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	heading     = regexp.MustCompile(`(?m)^#{1,6}[ \t]+(.+?)[ \t#]*$`)
+	bullet      = regexp.MustCompile(`(?m)^([ \t]*)[*-][ \t]+`)
+	htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// outputFilter applies the output policy to the answers.
+type outputFilter struct {
+	topics  []*regexp.Regexp
+	domains []string
+}
+
+func newOutputFilter(policy OutputPolicy) *outputFilter {
+	f := &outputFilter{}
+	for _, topic := range policy.BlockedTopics {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		f.topics = append(f.topics, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(topic)+`\b`))
+	}
+	for _, domain := range policy.AllowedDomains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			f.domains = append(f.domains, domain)
+		}
+	}
+
+	return f
+}
+
+// blockedTopic returns the blocked topic the answer mentions.
+func (f *outputFilter) blockedTopic(answer string) (string, bool) {
+	for _, topic := range f.topics {
+		if match := topic.FindString(answer); match != "" {
+			return match, true
+		}
+	}
+
+	return "", false
+}
+
+// filterLinks flattens markdown links into their text and address and removes the links to the domains
+// which aren't allowed.
+func (f *outputFilter) filterLinks(answer string) string {
+	answer = markdownLink.ReplaceAllString(answer, "$1 ($2)")
+
+	return link.ReplaceAllStringFunc(answer, func(match string) string {
+		trimmed := strings.TrimRight(match, linkTrailing)
+		if f.allowed(trimmed) {
+			return match
+		}
+
+		return linkRemoved + match[len(trimmed):]
+	})
+}
+
+func (f *outputFilter) allowed(url string) bool {
+	host := strings.ToLower(url)
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#:"); i >= 0 {
+		host = host[:i]
+	}
+
+	for _, domain := range f.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatHTML escapes the answer for the HTML parse mode and turns the markdown the models like to use,
+// e.g. bold text, headings, lists and code, into its telegram counterparts.
+func formatHTML(text string) string {
+	text = htmlEscaper.Replace(text)
+
+	// the code is set aside while the rest is formatted, telegram doesn't accept entities inside it
+	var spans []string
+	text = codeSpan.ReplaceAllStringFunc(text, func(match string) string {
+		m := codeSpan.FindStringSubmatch(match)
+		span := "<code>" + m[2] + "</code>"
+		if strings.HasPrefix(match, codeFence) {
+			// a block cut by the split may be left empty, telegram refuses empty entities
+			span = ""
+			if strings.TrimSpace(m[1]) != "" {
+				span = "<pre>" + m[1] + "</pre>"
+			}
+		}
+		spans = append(spans, span)
+		return "\x00" + strconv.Itoa(len(spans)-1) + "\x00"
+	})
+
+	text = heading.ReplaceAllString(text, "<b>$1</b>")
+	text = bold.ReplaceAllString(text, "<b>$1$2</b>")
+	text = bullet.ReplaceAllString(text, "$1• ")
+
+	return codeRef.ReplaceAllStringFunc(text, func(ref string) string {
+		i, _ := strconv.Atoi(ref[1 : len(ref)-1])
+		return spans[i]
+	})
+}
+
+// splitAnswer splits the answer like splitMessage. A code block cut in two is closed at the end of the part
+// and opened again at the start of the next one, so every part is formatted on its own.
+func splitAnswer(text string, limit int) []string {
+	parts := splitMessage(text, limit-textLength(codeFence+"\n\n"+codeFence))
+
+	open := false
+	for i, part := range parts {
+		if open {
+			part = codeFence + "\n" + part
+		}
+		open = strings.Count(part, codeFence)%2 == 1
+		if open {
+			part += "\n" + codeFence
+		}
+		parts[i] = part
+	}
+
+	return parts
+}
+
+// splitMessage splits the text into parts telegram accepts, at paragraphs when possible, then at lines,
+// then at words. Lengths are counted in UTF-16 code units, as telegram does.
+func splitMessage(text string, limit int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if textLength(text) <= limit {
+		return []string{text}
+	}
+
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		pieces := strings.Split(text, sep)
+		if len(pieces) == 1 {
+			continue
+		}
+
+		var parts []string
+		var part string
+		for _, piece := range pieces {
+			if part != "" && textLength(part)+textLength(sep)+textLength(piece) > limit {
+				// a part over the limit is a single piece, it is split at the next separator
+				parts = append(parts, splitMessage(part, limit)...)
+				part = ""
+			}
+			if part != "" {
+				part += sep
+			}
+			part += piece
+		}
+
+		return append(parts, splitMessage(part, limit)...)
+	}
+
+	// a single word over the limit
+	var parts []string
+	units := utf16.Encode([]rune(text))
+	for len(units) > limit {
+		cut := limit
+		// don't cut a surrogate pair in half
+		if high := units[cut-1]; high >= 0xd800 && high < 0xdc00 {
+			cut--
+		}
+		parts = append(parts, string(utf16.Decode(units[:cut])))
+		units = units[cut:]
+	}
+
+	return append(parts, string(utf16.Decode(units)))
+}
+
+func textLength(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
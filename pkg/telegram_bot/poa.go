@@ -0,0 +1,208 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxPoaPages limits the number of pages of a proof of address document.
+const maxPoaPages = 5
+
+// poaCategory is a kind of proof of address document the user can choose.
+type poaCategory struct {
+	id           string
+	title        string
+	instructions string
+}
+
+var poaCategories = []poaCategory{
+	{
+		id:    "utility_bill",
+		title: "Utility bill",
+		instructions: "Please attach your <b>utility bill</b> (electricity, water, gas, internet or landline phone).\n\n" +
+			"- It must be issued within the last 3 months.\n" +
+			"- Your full name and address must be clearly visible.\n" +
+			"- The whole page must be in the frame, including all four corners.",
+	},
+	{
+		id:    "residence_registration",
+		title: "Residence registration",
+		instructions: "Please attach your <b>residence registration certificate</b> issued by a government authority.\n\n" +
+			"- It must be valid on the day of the verification.\n" +
+			"- Your full name, address and the issuing authority must be clearly visible.\n" +
+			"- The whole page must be in the frame, including stamps and signatures.",
+	},
+	{
+		id:    "bank_statement",
+		title: "Bank statement",
+		instructions: "Please attach your <b>bank statement</b>.\n\n" +
+			"- It must be issued within the last 3 months.\n" +
+			"- Your full name, address and the name of the bank must be clearly visible.\n" +
+			"- The whole page must be in the frame. You can hide transactions, but not the header of the statement.",
+	},
+}
+
+func poaCategoryByID(id string) (poaCategory, bool) {
+	for _, category := range poaCategories {
+		if category.id == id {
+			return category, true
+		}
+	}
+
+	return poaCategory{}, false
+}
+
+func poaCategoryKeyboard() tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(poaCategories))
+	for _, category := range poaCategories {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(category.title, poaCategoryData+":"+category.id),
+		))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (t *TelegramBot) promptPoaCategory(chatID int64, s *flow.Session) error {
+	msg := tgbotapi.NewMessage(chatID, SelectedPoaDocument)
+	msg.ReplyMarkup = poaCategoryKeyboard()
+	_, err := t.send(msg)
+	if err != nil || !flow.PoaOptional(s) {
+		return err
+	}
+
+	msg = tgbotapi.NewMessage(chatID, PoaSkipPrompt)
+	msg.ReplyMarkup = skipPoaKeyboard
+	_, err = t.send(msg)
+	return err
+}
+
+func (t *TelegramBot) promptPoa(chatID int64, s *flow.Session) error {
+	category, ok := poaCategoryByID(s.PoaCategory)
+	if !ok {
+		return fmt.Errorf("undefined poa category %q", s.PoaCategory)
+	}
+
+	return t.sendHTML(chatID, category.instructions+"\n\n"+fmt.Sprintf(poaPagesHint, maxPoaPages), nil)
+}
+
+// poaCategoryCallback stores the proof of address category chosen by the user and asks for the document.
+func (t *TelegramBot) poaCategoryCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery, id string) error {
+	category, ok := poaCategoryByID(id)
+	if !ok {
+		err := t.answerCallback(callbackQuery, buttonUnavailable)
+		if err != nil {
+			return err
+		}
+		return errors.New("undefined poa category")
+	}
+
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, fmt.Sprintf(poaCategoryChosen, category.title))
+	if err != nil {
+		return err
+	}
+
+	tgID := strconv.FormatInt(callbackQuery.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+
+	session.PoaCategory = category.id
+	return t.choiceMade(ctx, callbackQuery.From.ID, session, flow.EventCategorySelected)
+}
+
+// skipPoaCallback skips the optional proof of address step. The user is told the step has been skipped only
+// once the session has moved on, otherwise the button is removed: pages of the document have been uploaded,
+// the step is required or the button is stale.
+func (t *TelegramBot) skipPoaCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error {
+	tgID := strconv.FormatInt(callbackQuery.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+
+	err = t.fire(ctx, tgID, session, flow.EventSkipped)
+	if errors.Is(err, flow.ErrNotAllowed) {
+		text := buttonUnavailable
+		if session.State == flow.StatePoa && session.Pages(flow.StatePoa) > 0 {
+			text = poaSkipUnavailable
+		}
+		err = t.answerCallback(callbackQuery, text)
+		if err != nil {
+			return err
+		}
+		return t.removeKeyboard(callbackQuery, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	err = t.answerCallback(callbackQuery, poaSkipped)
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, poaSkipped)
+	if err != nil {
+		return err
+	}
+
+	return t.nextCheck(callbackQuery.From.ID, session)
+}
+
+// poaDoneCallback completes the proof of address step once the user has uploaded all pages.
+func (t *TelegramBot) poaDoneCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error {
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+
+	tgID := strconv.FormatInt(callbackQuery.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+
+	return t.choiceMade(ctx, callbackQuery.From.ID, session, flow.EventUploaded)
+}
+
+// poaPagesUploaded keeps the proof of address step open for further pages until the user presses done
+// or the page limit is reached.
+func (t *TelegramBot) poaPagesUploaded(ctx context.Context, chatID int64, session *flow.Session) error {
+	tgID := strconv.FormatInt(chatID, 10)
+	pages := session.Pages(flow.StatePoa)
+	if pages >= maxPoaPages {
+		err := t.fire(ctx, tgID, session, flow.EventUploaded)
+		if err != nil {
+			return err
+		}
+		err = t.sendHTML(chatID, fmt.Sprintf(poaPagesLimitReached, maxPoaPages), nil)
+		if err != nil {
+			return err
+		}
+
+		return t.nextCheck(chatID, session)
+	}
+
+	err := t.cache.SetSession(ctx, tgID, session)
+	if err != nil {
+		return err
+	}
+	t.scheduleReminders(ctx, tgID, session, time.Now())
+
+	return t.sendHTML(chatID, fmt.Sprintf(poaPageReceived, pages), poaDoneKeyboard)
+}
@@ -0,0 +1,107 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/dataspike-io/docver-sdk-go"
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// poiUploaded pairs the sides of two-sided documents using the side detected by dataspike.
+// A document is two-sided when dataspike detects it so or the user has chosen a two-sided document.
+func (t *TelegramBot) poiUploaded(ctx context.Context, chatID int64, session *flow.Session, doc *dataspike.Document) error {
+	tgID := strconv.FormatInt(chatID, 10)
+	side := detectedSide(doc)
+	twoSided := (doc.DetectedTwoSideDocument != nil && *doc.DetectedTwoSideDocument) || documentTwoSided(session)
+
+	switch {
+	case session.State == flow.StatePoiFront && twoSided && side == Back:
+		err := t.cache.SetSession(ctx, tgID, session)
+		if err != nil {
+			return err
+		}
+
+		return t.sendHTML(chatID, poiBackInsteadOfFront, nil)
+	case session.State == flow.StatePoiFront && twoSided:
+		session.FrontDocumentID = doc.DocumentId
+		err := t.fire(ctx, tgID, session, flow.EventFrontUploaded)
+		if err != nil {
+			return err
+		}
+		err = t.sendHTML(chatID, poiFrontReceived, nil)
+		if err != nil {
+			return err
+		}
+
+		return t.nextCheck(chatID, session)
+	case session.State == flow.StatePoiFront:
+		session.FrontDocumentID = doc.DocumentId
+		err := t.fire(ctx, tgID, session, flow.EventUploaded)
+		if err != nil {
+			return err
+		}
+
+		return t.nextCheck(chatID, session)
+	case side == Front:
+		// the document was uploaded as the back side, it can't replace the front one
+		err := t.cache.SetSession(ctx, tgID, session)
+		if err != nil {
+			return err
+		}
+
+		return t.sendHTML(chatID, poiFrontAgain, retakeFrontKeyboard)
+	default:
+		session.BackDocumentID = doc.DocumentId
+		err := t.fire(ctx, tgID, session, flow.EventUploaded)
+		if err != nil {
+			return err
+		}
+		err = t.sendHTML(chatID, poiBothSidesReceived, retakeBackKeyboard)
+		if err != nil {
+			return err
+		}
+
+		return t.nextCheck(chatID, session)
+	}
+}
+
+// retakeCallback returns the session to one of the document sides, so the user can upload it again.
+func (t *TelegramBot) retakeCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery, event flow.Event) error {
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+
+	tgID := strconv.FormatInt(callbackQuery.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+
+	err = t.fire(ctx, tgID, session, event)
+	if errors.Is(err, flow.ErrNotAllowed) {
+		_, err = t.send(tgbotapi.NewMessage(callbackQuery.From.ID, retakeUnavailable))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	return t.nextCheck(callbackQuery.From.ID, session)
+}
+
+// sendHTML sends a message with HTML markup and an optional keyboard.
+func (t *TelegramBot) sendHTML(chatID int64, text string, replyMarkup interface{}) error {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = replyMarkup
+	_, err := t.send(msg)
+	return err
+}
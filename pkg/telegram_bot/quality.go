@@ -0,0 +1,72 @@
+package telegram_bot
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	"github.com/dataspike-io/docver-tg-bot/pkg/imaging"
+)
+
+// retakeAdvice tells the user how to avoid the problem with the next photo.
+var retakeAdvice = map[imaging.Problem]string{
+	imaging.ProblemResolution: "The photo is too small. Take it closer, so the document fills the frame, and don't send a thumbnail.",
+	imaging.ProblemDark:       "The photo is too dark. Move to a well lit place or turn on the light.",
+	imaging.ProblemBright:     "The photo is overexposed. Avoid direct light and glare, turn off the flash.",
+	imaging.ProblemContrast:   "The photo is washed out. Put the document on a plain dark surface and avoid glare.",
+	imaging.ProblemBlur:       "The photo is blurry. Hold the phone still and wait for the camera to focus.",
+}
+
+// poorQualityError is returned when the image is clearly unusable and hasn't been uploaded to dataspike.
+type poorQualityError struct {
+	problems []imaging.Problem
+}
+
+func (e *poorQualityError) Error() string {
+	problems := make([]string, 0, len(e.problems))
+	for _, p := range e.problems {
+		problems = append(problems, string(p))
+	}
+
+	return "poor image quality: " + strings.Join(problems, ", ")
+}
+
+// qualityThresholds are the limits the photos of the step are checked against.
+func (t *TelegramBot) qualityThresholds(state flow.State) imaging.Thresholds {
+	if th, ok := t.thresholds[state]; ok {
+		return th
+	}
+
+	return imaging.DefaultThresholds
+}
+
+// checkQuality rejects the photo before it's uploaded when it clearly can't be used.
+func (t *TelegramBot) checkQuality(state flow.State, img image.Image) error {
+	problems := t.qualityThresholds(state).Check(imaging.Measure(img))
+	if len(problems) > 0 {
+		return &poorQualityError{problems: problems}
+	}
+
+	return nil
+}
+
+// qualityRejected keeps the step open and tells the user how to retake the photo. The photo hasn't reached
+// dataspike, so the attempt doesn't count against the retry budget.
+func (t *TelegramBot) qualityRejected(chatID int64, session *flow.Session, poor *poorQualityError) error {
+	t.log().Info("poor image quality",
+		"tg_id", strconv.FormatInt(chatID, 10),
+		"verification_id", session.Verification.Id,
+		"state", session.State,
+		"problems", poor.Error(),
+	)
+
+	advice := make([]string, 0, len(poor.problems))
+	for _, p := range poor.problems {
+		advice = append(advice, "- "+retakeAdvice[p])
+	}
+
+	return t.sendHTML(chatID, fmt.Sprintf(qualityRejectedText, html.EscapeString(strings.Join(advice, "\n"))), nil)
+}
@@ -0,0 +1,122 @@
+package telegram_bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	"github.com/dataspike-io/docver-tg-bot/pkg/reminder"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// droppedReminders counts the reminders that were due after their session was gone, by kind.
+var droppedReminders = expvar.NewMap("reminders_dropped")
+
+// scheduleReminders reschedules the reminders of the user after an activity in the session.
+// Failures are only logged, reminders aren't worth interrupting the verification for.
+func (t *TelegramBot) scheduleReminders(ctx context.Context, tgID string, s *flow.Session, now time.Time) {
+	if t.reminders == nil {
+		return
+	}
+	if !resumable(s.State) {
+		t.forgetReminders(ctx, tgID)
+		return
+	}
+
+	var jobs []reminder.Job
+	if t.idleReminder > 0 {
+		jobs = append(jobs, reminder.Job{TgID: tgID, Kind: reminder.KindIdle, At: now.Add(t.idleReminder)})
+	}
+	if t.expiryReminder > 0 && !s.Verification.ExpiresAt.IsZero() {
+		at := s.Verification.ExpiresAt.Add(-t.expiryReminder)
+		if at.After(now) {
+			jobs = append(jobs, reminder.Job{TgID: tgID, Kind: reminder.KindExpiry, At: at})
+		}
+	}
+
+	for _, job := range jobs {
+		err := t.reminders.Schedule(ctx, job)
+		if err != nil {
+			t.log().Warn("reminder scheduling failed", "tg_id", tgID, "kind", job.Kind, "error", err)
+		}
+	}
+}
+
+// forgetReminders drops the reminders of the user once there is nothing left to remind about.
+func (t *TelegramBot) forgetReminders(ctx context.Context, tgID string) {
+	if t.reminders == nil {
+		return
+	}
+
+	err := t.reminders.Cancel(ctx, tgID)
+	if err != nil {
+		t.log().Warn("reminder cancellation failed", "tg_id", tgID, "error", err)
+	}
+}
+
+// userActive pushes the idle reminder back after any update of the user, the updates keeping the user
+// in the step too, e.g. a rejected upload being retried.
+func (t *TelegramBot) userActive(ctx context.Context, user *tgbotapi.User) {
+	if t.reminders == nil || t.idleReminder <= 0 || user == nil {
+		return
+	}
+
+	tgID := strconv.FormatInt(user.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil || !resumable(session.State) {
+		return
+	}
+
+	// the due time is kept to the minute, so the updates of the same minute don't rewrite the store
+	at := time.Now().Add(t.idleReminder).Truncate(time.Minute)
+	err = t.reminders.Schedule(ctx, reminder.Job{TgID: tgID, Kind: reminder.KindIdle, At: at})
+	if err != nil {
+		t.log().Warn("reminder scheduling failed", "tg_id", tgID, "kind", reminder.KindIdle, "error", err)
+	}
+}
+
+// remind sends the reminder with a button taking the user back into the current step.
+// The scheduler runs it on a goroutine of its own, so it waits for the updates of the user being handled.
+func (t *TelegramBot) remind(ctx context.Context, job reminder.Job) error {
+	chatID, err := strconv.ParseInt(job.TgID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return t.chats.wait(chatID, func() error {
+		return t.sendReminder(ctx, chatID, job)
+	})
+}
+
+func (t *TelegramBot) sendReminder(ctx context.Context, chatID int64, job reminder.Job) error {
+	session, err := t.cache.GetSession(ctx, job.TgID)
+	if err != nil {
+		// the session has been completed or dropped since the reminder was scheduled, or it was lost
+		// on a restart: the reminders are stored to survive it, the sessions of the cache aren't
+		droppedReminders.Add(string(job.Kind), 1)
+		t.log().Info("reminder dropped, the session is gone", "tg_id", job.TgID, "kind", job.Kind)
+		return nil
+	}
+	if !resumable(session.State) {
+		return nil
+	}
+
+	title := stepFor(session.State).title
+	var text string
+	switch job.Kind {
+	case reminder.KindExpiry:
+		left := time.Until(session.Verification.ExpiresAt)
+		if left <= 0 {
+			return nil
+		}
+		text = fmt.Sprintf(reminderExpiry, formatRemaining(left), title)
+	default:
+		text = fmt.Sprintf(reminderIdle, title)
+	}
+
+	t.log().Info("reminder sent", "tg_id", job.TgID, "verification_id", session.Verification.Id, "kind", job.Kind)
+	return t.sendHTML(chatID, text, reminderKeyboard)
+}
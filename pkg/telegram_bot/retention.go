@@ -0,0 +1,71 @@
+package telegram_bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/retention"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// send sends the message and records it for deletion when message retention is enabled.
+func (t *TelegramBot) send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg, err := t.bot.Send(c)
+	if err != nil {
+		return msg, err
+	}
+	if msg.Chat != nil {
+		t.retain(msg.Chat.ID, msg.MessageID, msg.Time())
+	}
+
+	return msg, nil
+}
+
+// retain records the message to delete it once the retention window has passed.
+func (t *TelegramBot) retain(chatID int64, messageID int, sentAt time.Time) {
+	if t.janitor == nil || messageID == 0 {
+		return
+	}
+
+	msg := retention.Message{ChatID: chatID, MessageID: messageID, SentAt: sentAt}
+	err := t.janitor.Record(context.Background(), msg)
+	if err != nil {
+		t.log().Warn("message retention failed", "chat_id", chatID, "message_id", messageID, "error", err)
+	}
+}
+
+// retainIncoming records the message of the user. Photos and documents are deleted by deleteIncoming
+// as soon as they are handled, so only the other messages are left for the retention job.
+func (t *TelegramBot) retainIncoming(message *tgbotapi.Message) {
+	if message.Chat == nil || message.Photo != nil || message.Document != nil {
+		return
+	}
+
+	t.retain(message.Chat.ID, message.MessageID, message.Time())
+}
+
+// deleteIncoming deletes the photo or the document of the user once it is handled, whether it has been
+// uploaded or refused, the chat mustn't keep the documents of the user.
+func (t *TelegramBot) deleteIncoming(message *tgbotapi.Message) {
+	msg := retention.Message{ChatID: message.From.ID, MessageID: message.MessageID, SentAt: message.Time()}
+	err := t.deleteMessage(context.Background(), msg)
+	if err != nil {
+		t.messageNotDeleted(msg, err)
+	}
+}
+
+func (t *TelegramBot) deleteMessage(_ context.Context, msg retention.Message) error {
+	_, err := t.bot.Request(tgbotapi.NewDeleteMessage(msg.ChatID, msg.MessageID))
+	return err
+}
+
+// messageNotDeleted reports a message left in the chat, e.g. when it was deleted by the user
+// or the bot was down for longer than telegram allows to delete messages.
+func (t *TelegramBot) messageNotDeleted(msg retention.Message, err error) {
+	t.log().Warn("message not deleted",
+		"chat_id", msg.ChatID,
+		"message_id", msg.MessageID,
+		"sent_at", msg.SentAt,
+		"error", err,
+	)
+}
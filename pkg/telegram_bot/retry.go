@@ -0,0 +1,122 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/dataspike-io/docver-sdk-go"
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+)
+
+// defaultRetryBudget is the number of rejected uploads or failed liveness checks allowed in a step
+// unless configured with WithRetryBudget.
+const defaultRetryBudget = 3
+
+// rejectedUploadError is returned when dataspike has received the document but rejected it.
+type rejectedUploadError struct {
+	errors dataspike.Errors
+}
+
+func (e *rejectedUploadError) Error() string {
+	return e.errors.String()
+}
+
+func (t *TelegramBot) retryBudget(state flow.State) int {
+	if budget, ok := t.retryBudgets[state]; ok {
+		return budget
+	}
+
+	return defaultRetryBudget
+}
+
+// attemptsLeft is the number of uploads the user can still make in the current step of the session.
+func (t *TelegramBot) attemptsLeft(s *flow.Session) int {
+	return t.retryBudget(s.State) - s.Attempts[s.State]
+}
+
+// uploadRejected keeps the step open, explains why the document was rejected and counts the attempt.
+// Once the retry budget of the step runs out, the user is referred to the support.
+func (t *TelegramBot) uploadRejected(ctx context.Context, chatID int64, session *flow.Session, rejected *rejectedUploadError) error {
+	tgID := strconv.FormatInt(chatID, 10)
+	countAttempt(session, session.State)
+	err := t.cache.SetSession(ctx, tgID, session)
+	if err != nil {
+		return err
+	}
+
+	t.log().Info("upload rejected",
+		"tg_id", tgID,
+		"verification_id", session.Verification.Id,
+		"state", session.State,
+		"attempt", session.Attempts[session.State],
+		"errors", rejected.Error(),
+	)
+
+	left := t.attemptsLeft(session)
+	if left <= 0 {
+		return t.sendHTML(chatID, uploadAttemptsExhausted, contactUsKeyboard)
+	}
+
+	return t.sendHTML(chatID, fmt.Sprintf(uploadRejectedText, explainRejection(rejected.errors), left), nil)
+}
+
+// livenessFailed keeps the session, explains why the liveness check has failed and offers a new attempt.
+// Once the retry budget of the liveness step runs out, the verification is failed for good.
+func (t *TelegramBot) livenessFailed(ctx context.Context, chatID int64, session *flow.Session, reasons dataspike.Errors) error {
+	tgID := strconv.FormatInt(chatID, 10)
+	countAttempt(session, flow.StateLiveness)
+
+	t.log().Info("liveness failed",
+		"tg_id", tgID,
+		"verification_id", session.Verification.Id,
+		"attempt", session.Attempts[flow.StateLiveness],
+		"errors", reasons.String(),
+	)
+
+	left := t.retryBudget(flow.StateLiveness) - session.Attempts[flow.StateLiveness]
+	if left <= 0 {
+		err := t.cache.RemoveSession(ctx, tgID)
+		if err != nil {
+			return err
+		}
+		t.forgetReminders(ctx, tgID)
+
+		return t.sendHTML(chatID, livenessAttemptsExhausted, contactUsKeyboard)
+	}
+
+	err := t.cache.SetSession(ctx, tgID, session)
+	if err != nil {
+		return err
+	}
+
+	reason := explainRejection(reasons)
+	if reason == "" {
+		reason = "- " + livenessNoReason
+	}
+
+	return t.sendHTML(chatID, fmt.Sprintf(livenessRetry, LivenessFailed, reason, left), generateLivenessRetryKeyboard(t.livenessURL(session)))
+}
+
+func countAttempt(s *flow.Session, state flow.State) {
+	if s.Attempts == nil {
+		s.Attempts = make(map[flow.State]int)
+	}
+	s.Attempts[state]++
+}
+
+// explainRejection lists the reasons returned by dataspike, one per line.
+func explainRejection(errs dataspike.Errors) string {
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		reason := e.Message
+		if reason == "" {
+			reason = fmt.Sprintf("error code %d", e.Code)
+		}
+		lines = append(lines, "- "+html.EscapeString(reason))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,228 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dataspike-io/docver-sdk-go"
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/gofrs/uuid"
+)
+
+// statusRefreshInterval is how long the verification kept in the session is shown in the status report
+// before it is refreshed from dataspike.
+const statusRefreshInterval = time.Minute
+
+func (t *TelegramBot) statusCommand(ctx context.Context, message *tgbotapi.Message) error {
+	tgID := strconv.FormatInt(message.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		err2 := t.sendHTML(message.From.ID, verificationNotFound, contactUsKeyboard)
+		if err2 != nil {
+			return err2
+		}
+		return err
+	}
+
+	now := time.Now()
+	if now.Sub(session.RefreshedAt) > statusRefreshInterval {
+		err = t.refreshVerification(ctx, tgID, session, now)
+		if err != nil {
+			// the report is still useful with the data we already have
+			t.log().Warn("verification refresh failed", "tg_id", tgID, "verification_id", session.Verification.Id, "error", err)
+		}
+	}
+
+	return t.sendHTML(message.From.ID, statusReport(session, now), statusKeyboard(session.State))
+}
+
+// resumeCommand rebuilds the session from the verification kept by dataspike and continues with the step it needs.
+func (t *TelegramBot) resumeCommand(ctx context.Context, message *tgbotapi.Message) error {
+	tgID := strconv.FormatInt(message.From.ID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		err2 := t.sendHTML(message.From.ID, verificationNotFound, contactUsKeyboard)
+		if err2 != nil {
+			return err2
+		}
+		return err
+	}
+
+	verification, err := t.dsClient.GetVerificationByID(uuid.FromStringOrNil(session.Verification.Id))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	change := verificationFlow.Resync(session, verification, now)
+	session.RefreshedAt = now
+	t.log().Info("verification resynced",
+		"tg_id", tgID,
+		"verification_id", verification.Id,
+		"from", change.From,
+		"to", change.To,
+	)
+
+	err = t.cache.SetSession(ctx, tgID, session)
+	if err != nil {
+		return err
+	}
+	t.scheduleReminders(ctx, tgID, session, now)
+
+	// the verification is over without being verified, there is nothing left to resume
+	switch verification.Status {
+	case "failed":
+		return t.sendHTML(message.From.ID, VerificationFailed, contactUsKeyboard)
+	case "expired":
+		return t.sendHTML(message.From.ID, expiredText, contactUsKeyboard)
+	case "canceled":
+		return t.sendHTML(message.From.ID, cancelText, contactUsKeyboard)
+	}
+
+	// the documents have already been submitted, proceeding again would fail
+	if change.From == flow.StateReview && change.To == flow.StateReview {
+		_, err = t.send(tgbotapi.NewMessage(message.From.ID, verificationUnderReview))
+		return err
+	}
+
+	return t.nextCheck(message.From.ID, session)
+}
+
+// refreshVerification replaces the verification kept in the session with the one dataspike has.
+func (t *TelegramBot) refreshVerification(ctx context.Context, tgID string, session *flow.Session, now time.Time) error {
+	verification, err := t.dsClient.GetVerificationByShortID(session.Verification.VerificationUrlId)
+	if err != nil {
+		return err
+	}
+
+	session.Verification = verification
+	session.RefreshedAt = now
+	return t.cache.SetSession(ctx, tgID, session)
+}
+
+// statusReport describes the progress of every check of the verification.
+func statusReport(s *flow.Session, now time.Time) string {
+	var b strings.Builder
+	v := s.Verification
+	fmt.Fprintf(&b, "<b>Verification status:</b> %s\n", humanize(v.Status))
+	if title := stepFor(s.State).title; title != "" {
+		fmt.Fprintf(&b, "<b>Current step:</b> %s\n", title)
+	}
+
+	b.WriteString("\n")
+	if v.Checks.DocumentMrz != nil {
+		writeCheck(&b, "Identity document", &v.Checks.DocumentMrz.Check)
+	}
+	writeCheck(&b, "Liveness", v.Checks.Liveness)
+	writeCheck(&b, "Face comparison", v.Checks.FaceComparison)
+	writeCheck(&b, "Proof of address", v.Checks.Poa)
+
+	switch left := v.ExpiresAt.Sub(now); {
+	case v.ExpiresAt.IsZero():
+	case left <= 0:
+		b.WriteString("\nThe verification has expired.")
+	default:
+		fmt.Fprintf(&b, "\n<b>Time left:</b> %s", formatRemaining(left))
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// writeCheck adds a line for the check, checks not required by the verification are skipped.
+func writeCheck(b *strings.Builder, name string, check *dataspike.Check) {
+	if check == nil {
+		return
+	}
+
+	fmt.Fprintf(b, "%s: %s\n", name, humanize(check.Status))
+	for _, e := range check.Errors {
+		fmt.Fprintf(b, "  - %s\n", html.EscapeString(e.Message))
+	}
+}
+
+func humanize(status string) string {
+	if status == "" {
+		return "unknown"
+	}
+
+	return html.EscapeString(strings.ReplaceAll(status, "_", " "))
+}
+
+// formatRemaining rounds the duration down to the two largest units, e.g. "1d 4h" or "25m".
+func formatRemaining(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm", minutes)
+	default:
+		return "less than a minute"
+	}
+}
+
+// statusKeyboard offers to continue the current step and to cancel the verification while it is in progress.
+func statusKeyboard(state flow.State) interface{} {
+	if state == flow.StateDone {
+		return nil
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if resumable(state) {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Resume", statusResume))
+	}
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData("Cancel verification", statusCancel))
+
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// resumable reports whether the prompt of the state can be repeated, the review one would submit the documents again.
+func resumable(state flow.State) bool {
+	return state != flow.StateReview && state != flow.StateDone
+}
+
+// resumeCallback repeats the prompt of the current step.
+func (t *TelegramBot) resumeCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error {
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+
+	session, err := t.cache.GetSession(ctx, strconv.FormatInt(callbackQuery.From.ID, 10))
+	if err != nil {
+		return err
+	}
+	if !resumable(session.State) {
+		_, err = t.send(tgbotapi.NewMessage(callbackQuery.From.ID, buttonUnavailable))
+		return err
+	}
+
+	return t.nextCheck(callbackQuery.From.ID, session)
+}
+
+func (t *TelegramBot) cancelCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error {
+	err := t.answerCallback(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+	err = t.removeKeyboard(callbackQuery, "")
+	if err != nil {
+		return err
+	}
+
+	return t.cancelVerification(ctx, callbackQuery.From.ID)
+}
@@ -0,0 +1,148 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var verificationFlow = flow.NewMachine(flow.Transitions)
+
+// step describes what the bot does while a session is in a state.
+type step struct {
+	// title describes the step in the status report.
+	title string
+	// docType is the dataspike document type accepted in the state, empty when no upload is expected.
+	docType string
+	// choice steps wait for a button press instead of an upload.
+	choice bool
+	// help tells what the user is expected to do in the step, empty when there is nothing to do.
+	help   string
+	prompt func(t *TelegramBot, chatID int64, s *flow.Session) error
+}
+
+func stepFor(state flow.State) step {
+	switch state {
+	case flow.StatePoiType:
+		return step{title: "choosing the identity document", help: "Choose the document you would like to verify your identity with, using the buttons above.", choice: true, prompt: (*TelegramBot).promptDocumentType}
+	case flow.StatePoiCountry:
+		return step{title: "choosing the issuing country", help: "Choose the country which has issued your document, using the buttons above.", choice: true, prompt: (*TelegramBot).promptCountry}
+	case flow.StatePoiFront:
+		return step{title: "uploading the identity document", help: "Send a photo of your document right here in the chat, as a photo or as a file.", docType: Poi, prompt: (*TelegramBot).promptPoi}
+	case flow.StatePoiBack:
+		return step{title: "uploading the back side of the identity document", help: "Send a photo of <b>the back side</b> of your document right here in the chat.", docType: Poi, prompt: (*TelegramBot).promptPoiBack}
+	case flow.StateLiveness:
+		return step{title: "liveness check", help: "Open the liveness link above and follow the instructions on the screen.", prompt: (*TelegramBot).promptLiveness}
+	case flow.StateSelfie:
+		return step{title: "uploading a selfie", help: "Send a photo of your face right here in the chat.", docType: Selfie, prompt: (*TelegramBot).promptSelfie}
+	case flow.StatePoaCategory:
+		return step{title: "choosing the proof of address document", help: "Choose the type of your proof of address document using the buttons above, or skip this step.", choice: true, prompt: (*TelegramBot).promptPoaCategory}
+	case flow.StatePoa:
+		return step{title: "uploading the proof of address", help: "Send the pages of your proof of address right here in the chat, then press <b>Done</b>.", docType: Poa, prompt: (*TelegramBot).promptPoa}
+	case flow.StateReview:
+		return step{title: "documents are being reviewed", prompt: (*TelegramBot).promptReview}
+	case flow.StateDone:
+		return step{title: "completed", prompt: (*TelegramBot).promptDone}
+	default:
+		return step{}
+	}
+}
+
+// fire applies the event to the session, logs the transition and persists the session.
+func (t *TelegramBot) fire(ctx context.Context, tgID string, s *flow.Session, event flow.Event) error {
+	now := time.Now()
+	change, err := verificationFlow.Fire(s, event, now)
+	if err != nil {
+		return err
+	}
+
+	t.log().Info("verification state changed",
+		"tg_id", tgID,
+		"verification_id", s.Verification.Id,
+		"event", change.Event,
+		"from", change.From,
+		"to", change.To,
+	)
+
+	err = t.cache.SetSession(ctx, tgID, s)
+	if err != nil {
+		return err
+	}
+	t.scheduleReminders(ctx, tgID, s, now)
+
+	return nil
+}
+
+// nextCheck prompts the user for the step the session is in.
+func (t *TelegramBot) nextCheck(chatID int64, s *flow.Session) error {
+	st := stepFor(s.State)
+	if st.prompt == nil {
+		return fmt.Errorf("state %q has no prompt", s.State)
+	}
+
+	return st.prompt(t, chatID, s)
+}
+
+func (t *TelegramBot) promptPoi(chatID int64, s *flow.Session) error {
+	var err error
+	if kind, ok := documentKindByID(s.DocumentType); ok {
+		text := fmt.Sprintf(poiAttachChosen, kind.page, kind.label, kind.mrzHint)
+		if kind.twoSided {
+			text += poiBackSideNext
+		}
+		err = t.sendHTML(chatID, text, nil)
+	} else {
+		_, err = t.send(tgbotapi.NewMessage(chatID, poiAttachDocument))
+	}
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, poiHelpForButton)
+	msg.ReplyMarkup = mzrKeyboard
+	_, err = t.send(msg)
+	return err
+}
+
+func (t *TelegramBot) promptPoiBack(chatID int64, s *flow.Session) error {
+	if kind, ok := documentKindByID(s.DocumentType); ok {
+		return t.sendHTML(chatID, fmt.Sprintf(poiAttachBackChosen, kind.label, kind.mrzHint), retakeFrontKeyboard)
+	}
+
+	return t.sendHTML(chatID, AttachBackSideOfDoc, retakeFrontKeyboard)
+}
+
+func (t *TelegramBot) promptLiveness(chatID int64, s *flow.Session) error {
+	msg := tgbotapi.NewMessage(chatID, LivenessPrompt)
+	msg.ReplyMarkup = generateLivenessKeyboard(t.livenessURL(s))
+	_, err := t.send(msg)
+	return err
+}
+
+// livenessURL is the link to the liveness widget, the same for the first attempt and the retries.
+func (t *TelegramBot) livenessURL(s *flow.Session) string {
+	return fmt.Sprintf("%s?source=telegram&botName=%s", s.Verification.VerificationUrl, t.bot.Self.UserName)
+}
+
+func (t *TelegramBot) promptSelfie(chatID int64, _ *flow.Session) error {
+	_, err := t.send(tgbotapi.NewMessage(chatID, AttachSelfiePrompt))
+	return err
+}
+
+func (t *TelegramBot) promptReview(chatID int64, s *flow.Session) error {
+	err := t.dsClient.ProceedVerification(s.Verification.VerificationUrlId)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.send(tgbotapi.NewMessage(chatID, VerificationStartedPleaseWait))
+	return err
+}
+
+func (t *TelegramBot) promptDone(chatID int64, _ *flow.Session) error {
+	_, err := t.send(tgbotapi.NewMessage(chatID, verificationCompleted))
+	return err
+}
@@ -1,17 +1,25 @@
 package telegram_bot
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"github.com/ayush6624/go-chatgpt"
 	"github.com/gofrs/uuid"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dataspike-io/docver-sdk-go"
+	"github.com/dataspike-io/docver-tg-bot/pkg/conversation"
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	"github.com/dataspike-io/docver-tg-bot/pkg/imaging"
+	"github.com/dataspike-io/docver-tg-bot/pkg/redact"
+	"github.com/dataspike-io/docver-tg-bot/pkg/reminder"
+	"github.com/dataspike-io/docver-tg-bot/pkg/retention"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -20,11 +28,11 @@ type IHTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-// ICache is the type needed for the bot to keep data of verifications.
+// ICache is the type needed for the bot to keep verification sessions.
 type ICache interface {
-	GetVerification(context.Context, string) (*dataspike.Verification, error)
-	SetVerification(context.Context, string, *dataspike.Verification) error
-	RemoveVerification(context.Context, string) error
+	GetSession(context.Context, string) (*flow.Session, error)
+	SetSession(context.Context, string, *flow.Session) error
+	RemoveSession(context.Context, string) error
 }
 
 type ITelegramBot interface {
@@ -32,21 +40,60 @@ type ITelegramBot interface {
 	ParseCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error
 	ParseCommand(ctx context.Context, message *tgbotapi.Message) error
 	ParseDocument(ctx context.Context, message *tgbotapi.Message) error
+	ParseAlbum(ctx context.Context, messages []*tgbotapi.Message) error
 	ParseText(ctx context.Context, message *tgbotapi.Message) error
 	SendVerificationStatus(ctx context.Context, applicantID string, status string) error
-	CheckLiveness(ctx context.Context, applicantId string, status string) error
+	CheckLiveness(ctx context.Context, applicantId string, status string, reasons dataspike.Errors) error
 }
 
 type Option func(bot *TelegramBot)
 
 type TelegramBot struct {
-	bot        *tgbotapi.BotAPI
-	dsClient   dataspike.IDataspikeClient
-	gptClient  *chatgpt.Client
-	httpClient IHTTPClient
-	cache      ICache
-	dev        bool
-	prompt     string
+	bot      *tgbotapi.BotAPI
+	dsClient dataspike.IDataspikeClient
+	llm      LLM
+	expert   expertModes
+	// expertTimeout ends expert mode after the user has been silent for it.
+	expertTimeout time.Duration
+	// output filters the answers of the LLM, defaultOutputPolicy applies when nil.
+	output         *outputFilter
+	knowledge      KnowledgeBase
+	knowledgeLimit int
+	// expertMemory keeps the recent questions to the LLM, they are forgotten right away when nil.
+	expertMemory *conversation.Memory
+	httpClient   IHTTPClient
+	cache        ICache
+	// chats run the updates, the webhooks and the reminders of a user one by one.
+	chats     chatQueues
+	callbacks callbackGuard
+	commands  *commandRegistry
+	// menus remembers the published command menus, stale menus are left in place when nil.
+	menus       IMenuStore
+	admins      []int64
+	logger      *slog.Logger
+	albumWindow time.Duration
+	// retryBudgets overrides defaultRetryBudget for some steps.
+	retryBudgets map[flow.State]int
+	// thresholds overrides imaging.DefaultThresholds for some steps.
+	thresholds   map[flow.State]imaging.Thresholds
+	maxImageEdge int
+	// fileTypes overrides the MIME types accepted in some steps.
+	fileTypes   map[flow.State][]string
+	maxFileSize int
+	// downloadAttempts, downloadTimeout and downloadBackoff override the defaults of the file downloads.
+	downloadAttempts int
+	downloadTimeout  time.Duration
+	downloadBackoff  time.Duration
+	reminders        *reminder.Scheduler
+	janitor          *retention.Janitor
+	// secrets are redacted from errors and logs along with the bot token.
+	secrets  []string
+	redactor *redact.Redactor
+	// idleReminder and expiryReminder are the delays of the reminders, zero disables the reminder.
+	idleReminder   time.Duration
+	expiryReminder time.Duration
+	dev            bool
+	prompt         string
 }
 
 func (t *TelegramBot) Start(ctx context.Context, offset, timeout int) {
@@ -54,184 +101,275 @@ func (t *TelegramBot) Start(ctx context.Context, offset, timeout int) {
 	u.Timeout = timeout
 
 	updates := t.bot.GetUpdatesChan(u)
+	albums := newAlbumCollector(t.albumWindow, ctx.Done())
+	if t.reminders != nil {
+		go t.reminders.Run(ctx)
+	}
+	if t.janitor != nil {
+		go t.janitor.Run(ctx)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case messages := <-albums.ready:
+			t.chats.do(messages[0].From.ID, func() { t.handleAlbum(ctx, messages) })
 		case update := <-updates:
-			if update.CallbackQuery != nil {
-				err := t.ParseCallback(ctx, update.CallbackQuery)
-				if err != nil {
-					// TODO: logging
-					continue
-				}
-			}
-
-			if update.Message == nil { // ignore any non-Message updates
-				continue
-			}
-
-			if update.Message.IsCommand() {
-				err := t.ParseCommand(ctx, update.Message)
-				if err != nil {
-					// TODO: logging
-				}
-			} else if update.Message.Photo != nil || update.Message.Document != nil {
-				err := t.ParseDocument(ctx, update.Message)
-				if err != nil {
-					// TODO: logging
-					_, err = t.bot.Send(tgbotapi.NewMessage(update.Message.From.ID, "For start verification, please use command /start_verification"))
-					if err != nil {
-						// TODO: logging
-					}
-				}
-			} else if update.Message.Text != "" {
-				err := t.ParseText(ctx, update.Message)
-				if err != nil {
-					// TODO: logging
-					_, err = t.bot.Send(tgbotapi.NewMessage(update.Message.From.ID, "Sorry, I didn't understand the message"))
-					if err != nil {
-						// TODO: logging
-					}
-				}
+			var chatID int64
+			if user := update.SentFrom(); user != nil {
+				chatID = user.ID
 			}
+			t.chats.do(chatID, func() { t.handleUpdate(ctx, update, albums) })
 		}
 	}
 }
 
-func (t *TelegramBot) ParseCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error {
-	switch callbackQuery.Data {
-	case learnMrz:
-		_, err := t.bot.Send(tgbotapi.NewMessage(callbackQuery.From.ID, mzrText))
-		if err != nil {
-			return err
-		}
-		photo := tgbotapi.NewPhoto(callbackQuery.From.ID, tgbotapi.FileURL(mrzLink))
-		_, err = t.bot.Send(photo)
-		return err
-	case skipPoa:
-		tgID := strconv.FormatInt(callbackQuery.From.ID, 10)
-		verification, err := t.cache.GetVerification(ctx, tgID)
-		if err != nil {
-			return err
-		}
-		verification.Checks.Poa = nil
-		err = t.cache.SetVerification(ctx, tgID, verification)
+func (t *TelegramBot) handleAlbum(ctx context.Context, messages []*tgbotapi.Message) {
+	defer t.userActive(ctx, messages[0].From)
+
+	err := t.ParseAlbum(ctx, messages)
+	if err != nil {
+		t.updateFailed("album", messages[0].From.ID, err)
+		_, err = t.send(tgbotapi.NewMessage(messages[0].From.ID, "For start verification, please use command /start_verification"))
 		if err != nil {
-			// TODO: logging
-			return err
+			t.updateFailed("reply", messages[0].From.ID, err)
 		}
-
-		return t.nextCheck(callbackQuery.From.ID, verification)
-	default:
-		return errors.New("undefined button data")
 	}
 }
 
-func (t *TelegramBot) ParseCommand(ctx context.Context, message *tgbotapi.Message) error {
-	msg := tgbotapi.NewMessage(message.From.ID, "")
-	switch message.Command() {
-	case "start":
-		if message.From.IsBot {
-			_, err := t.bot.Send(tgbotapi.NewMessage(message.From.ID, verificationForBotIsDisabled))
-			return err
-		}
+func (t *TelegramBot) handleUpdate(ctx context.Context, update tgbotapi.Update, albums *albumCollector) {
+	defer t.userActive(ctx, update.SentFrom())
 
-		arg := message.CommandArguments()
-		if arg == "" {
-			_, err := t.bot.Send(msg)
-			return err
-		}
-
-		verification, err := t.dsClient.GetVerificationByShortID(arg)
+	if update.CallbackQuery != nil {
+		err := t.ParseCallback(ctx, update.CallbackQuery)
 		if err != nil {
-			return err
+			t.updateFailed("callback", update.CallbackQuery.From.ID, err)
+			return
 		}
+	}
 
-		switch verification.Status {
-		case "expired":
-			msg.Text = expiredText
-			msg.ParseMode = tgbotapi.ModeHTML
-			msg.ReplyMarkup = contactUsKeyboard
-			_, err = t.bot.Send(msg)
-			return err
-		case verified:
-			_, err = t.bot.Send(tgbotapi.NewMessage(message.From.ID, verificationCompleted))
-			return err
-		}
+	if update.Message == nil { // ignore any non-Message updates
+		return
+	}
+	t.retainIncoming(update.Message)
 
-		applicant, err := t.dsClient.GetApplicantByID(uuid.FromStringOrNil(verification.ApplicantID))
+	if update.Message.IsCommand() {
+		err := t.ParseCommand(ctx, update.Message)
 		if err != nil {
-			return err
+			t.updateFailed("command", update.Message.From.ID, err)
 		}
-
-		tgID := strconv.FormatInt(message.From.ID, 10)
-		err = t.dsClient.LinkTelegramProfile(applicant.ApplicantId, tgID)
+	} else if update.Message.MediaGroupID != "" {
+		albums.add(update.Message)
+	} else if update.Message.Photo != nil || update.Message.Document != nil {
+		err := t.ParseDocument(ctx, update.Message)
 		if err != nil {
-			return err
+			t.updateFailed("document", update.Message.From.ID, err)
+			_, err = t.send(tgbotapi.NewMessage(update.Message.From.ID, "For start verification, please use command /start_verification"))
+			if err != nil {
+				t.updateFailed("reply", update.Message.From.ID, err)
+			}
 		}
-
-		err = t.cache.SetVerification(ctx, tgID, verification)
+	} else if update.Message.Text != "" {
+		err := t.ParseText(ctx, update.Message)
 		if err != nil {
-			return err
+			t.updateFailed("text", update.Message.From.ID, err)
+			_, err = t.send(tgbotapi.NewMessage(update.Message.From.ID, "Sorry, I didn't understand the message"))
+			if err != nil {
+				t.updateFailed("reply", update.Message.From.ID, err)
+			}
 		}
+	}
+}
 
-		_, err = t.bot.Send(tgbotapi.NewMessage(message.From.ID, startText))
+func (t *TelegramBot) ParseCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error {
+	key := callbackKey(callbackQuery)
+	if !t.callbacks.acquire(key, time.Now()) {
+		return t.answerCallback(callbackQuery, buttonAlreadyPressed)
+	}
+
+	err := t.handleCallback(ctx, callbackQuery)
+	if err != nil {
+		// the press wasn't handled, a retry must not be taken for a duplicate
+		t.callbacks.release(key)
+	}
+
+	return err
+}
+
+func (t *TelegramBot) handleCallback(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) error {
+	data, arg, _ := strings.Cut(callbackQuery.Data, ":")
+	switch data {
+	case learnMrz:
+		err := t.answerCallback(callbackQuery, "")
 		if err != nil {
 			return err
 		}
-		_, err = t.bot.Send(tgbotapi.NewMessage(message.From.ID, startVerificationInit))
+		err = t.removeKeyboard(callbackQuery, "")
 		if err != nil {
 			return err
 		}
-
-		return t.nextCheck(message.From.ID, verification)
-	case "help":
-		msg.Text = helpText
-		msg.ParseMode = tgbotapi.ModeHTML
-		msg.ReplyMarkup = contactUsKeyboard
-	case "cancel":
-		tgID := strconv.FormatInt(message.From.ID, 10)
-		v, err := t.cache.GetVerification(ctx, tgID)
+		_, err = t.send(tgbotapi.NewMessage(callbackQuery.From.ID, mzrText))
 		if err != nil {
 			return err
 		}
-
-		err = t.dsClient.CancelVerification(uuid.FromStringOrNil(v.Id))
+		photo := tgbotapi.NewPhoto(callbackQuery.From.ID, tgbotapi.FileURL(mrzLink))
+		_, err = t.send(photo)
+		return err
+	case skipPoa:
+		return t.skipPoaCallback(ctx, callbackQuery)
+	case retakeFront:
+		return t.retakeCallback(ctx, callbackQuery, flow.EventRetakeFront)
+	case retakeBack:
+		return t.retakeCallback(ctx, callbackQuery, flow.EventRetakeBack)
+	case documentTypeData:
+		return t.documentTypeCallback(ctx, callbackQuery, arg)
+	case issuedCountryData:
+		return t.countryCallback(ctx, callbackQuery, arg)
+	case poaCategoryData:
+		return t.poaCategoryCallback(ctx, callbackQuery, arg)
+	case poaDone:
+		return t.poaDoneCallback(ctx, callbackQuery)
+	case statusResume:
+		return t.resumeCallback(ctx, callbackQuery)
+	case statusCancel:
+		return t.cancelCallback(ctx, callbackQuery)
+	case exitExpert:
+		return t.exitExpertCallback(callbackQuery)
+	default:
+		err := t.answerCallback(callbackQuery, buttonUnavailable)
 		if err != nil {
 			return err
 		}
+		return errors.New("undefined button data")
+	}
+}
 
-		err = t.cache.RemoveVerification(ctx, tgID)
-		if err != nil {
-			return err
-		}
+func (t *TelegramBot) ParseCommand(ctx context.Context, message *tgbotapi.Message) error {
+	command, ok := t.commands.lookup(message.Command())
+	if !ok || !command.allowed(t.dev, t.isAdmin(message.From.ID)) {
+		_, err := t.send(tgbotapi.NewMessage(message.From.ID, unknownCommand))
+		return err
+	}
 
-		msg.Text = cancelText
-		msg.ReplyMarkup = contactUsKeyboard
-	case "ask_expert":
-		msg.Text = askExpertText
-	case "create_verification":
-		return t.createVerificationCommand(ctx, message)
-	case "customize_bot":
-		msg.Text = customizeBotText
+	return command.Handler(ctx, message)
+}
+
+func (t *TelegramBot) startCommand(ctx context.Context, message *tgbotapi.Message) error {
+	if message.From.IsBot {
+		_, err := t.send(tgbotapi.NewMessage(message.From.ID, verificationForBotIsDisabled))
+		return err
+	}
+
+	arg := message.CommandArguments()
+	if arg == "" {
+		_, err := t.send(tgbotapi.NewMessage(message.From.ID, ""))
+		return err
+	}
+
+	verification, err := t.dsClient.GetVerificationByShortID(arg)
+	if err != nil {
+		return err
+	}
+
+	switch verification.Status {
+	case "expired":
+		msg := tgbotapi.NewMessage(message.From.ID, expiredText)
+		msg.ParseMode = tgbotapi.ModeHTML
 		msg.ReplyMarkup = contactUsKeyboard
-	case "start_verification":
-		return t.startVerificationCommand(ctx, message)
-	default:
-		msg.Text = "Oops, that command is new to me!"
+		_, err = t.send(msg)
+		return err
+	case verified:
+		_, err = t.send(tgbotapi.NewMessage(message.From.ID, verificationCompleted))
+		return err
+	}
+
+	applicant, err := t.dsClient.GetApplicantByID(uuid.FromStringOrNil(verification.ApplicantID))
+	if err != nil {
+		return err
+	}
+
+	tgID := strconv.FormatInt(message.From.ID, 10)
+	err = t.dsClient.LinkTelegramProfile(applicant.ApplicantId, tgID)
+	if err != nil {
+		return err
+	}
+
+	session := flow.NewSession(verification)
+	err = t.fire(ctx, tgID, session, flow.EventStart)
+	if err != nil {
+		return err
 	}
 
-	_, err := t.bot.Send(msg)
+	_, err = t.send(tgbotapi.NewMessage(message.From.ID, startText))
+	if err != nil {
+		return err
+	}
+	_, err = t.send(tgbotapi.NewMessage(message.From.ID, startVerificationInit))
+	if err != nil {
+		return err
+	}
+
+	return t.nextCheck(message.From.ID, session)
+}
+
+func (t *TelegramBot) helpCommand(_ context.Context, message *tgbotapi.Message) error {
+	msg := tgbotapi.NewMessage(message.From.ID, helpText)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = contactUsKeyboard
+	_, err := t.send(msg)
 	return err
 }
 
-func (t *TelegramBot) createVerificationCommand(ctx context.Context, message *tgbotapi.Message) error {
-	if !t.dev {
-		_, err := t.bot.Send(tgbotapi.NewMessage(message.From.ID, "Oops, that command is new to me!"))
+func (t *TelegramBot) cancelCommand(ctx context.Context, message *tgbotapi.Message) error {
+	return t.cancelVerification(ctx, message.From.ID)
+}
+
+// cancelVerification cancels the verification of the user on dataspike and drops the session.
+func (t *TelegramBot) cancelVerification(ctx context.Context, chatID int64) error {
+	tgID := strconv.FormatInt(chatID, 10)
+	session, err := t.cache.GetSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+
+	err = t.dsClient.CancelVerification(uuid.FromStringOrNil(session.Verification.Id))
+	if err != nil {
 		return err
 	}
+
+	err = t.cache.RemoveSession(ctx, tgID)
+	if err != nil {
+		return err
+	}
+	t.forgetReminders(ctx, tgID)
+
+	msg := tgbotapi.NewMessage(chatID, cancelText)
+	msg.ReplyMarkup = contactUsKeyboard
+	_, err = t.send(msg)
+	return err
+}
+
+// askExpertCommand enters expert mode, where free text is answered by the LLM until the user exits
+// or stays silent for the expert timeout.
+func (t *TelegramBot) askExpertCommand(_ context.Context, message *tgbotapi.Message) error {
+	if t.llm == nil {
+		return t.sendHTML(message.From.ID, expertUnavailable, nil)
+	}
+
+	t.expert.enter(message.From.ID, time.Now().Add(t.expertTimeout))
+	text := askExpertText + "\n\n" + fmt.Sprintf(expertModeHint, formatRemaining(t.expertTimeout))
+	return t.sendHTML(message.From.ID, text, exitExpertKeyboard)
+}
+
+func (t *TelegramBot) customizeBotCommand(_ context.Context, message *tgbotapi.Message) error {
+	msg := tgbotapi.NewMessage(message.From.ID, customizeBotText)
+	msg.ReplyMarkup = contactUsKeyboard
+	_, err := t.send(msg)
+	return err
+}
+
+func (t *TelegramBot) createVerificationCommand(ctx context.Context, message *tgbotapi.Message) error {
 	tgID := strconv.FormatInt(message.From.ID, 10)
 	var applicantID string
 	applicant, err := t.dsClient.GetApplicantByExternalID(fmt.Sprintf("tg_user_%d", message.From.ID))
@@ -261,80 +399,73 @@ func (t *TelegramBot) createVerificationCommand(ctx context.Context, message *tg
 		return err
 	}
 
-	err = t.cache.SetVerification(ctx, tgID, verification)
+	session := flow.NewSession(verification)
+	err = t.fire(ctx, tgID, session, flow.EventStart)
 	if err != nil {
 		return err
 	}
 
-	_, err = t.bot.Send(tgbotapi.NewMessage(message.From.ID, fmt.Sprintf("Verification created successfully. VerificationShortID: %s, ApplicantID: %s", verification.VerificationUrlId, applicantID)))
+	_, err = t.send(tgbotapi.NewMessage(message.From.ID, fmt.Sprintf("Verification created successfully. VerificationShortID: %s, ApplicantID: %s", verification.VerificationUrlId, applicantID)))
 	if err != nil {
 		return err
 	}
 
-	return t.nextCheck(message.From.ID, verification)
+	return t.nextCheck(message.From.ID, session)
 }
 
 func (t *TelegramBot) startVerificationCommand(ctx context.Context, message *tgbotapi.Message) error {
 	if message.From.IsBot {
-		_, err := t.bot.Send(tgbotapi.NewMessage(message.From.ID, verificationForBotIsDisabled))
+		_, err := t.send(tgbotapi.NewMessage(message.From.ID, verificationForBotIsDisabled))
 		return err
 	}
-	verification, err := t.cache.GetVerification(ctx, strconv.FormatInt(message.From.ID, 10))
+	session, err := t.cache.GetSession(ctx, strconv.FormatInt(message.From.ID, 10))
 	if err != nil {
 		msg := tgbotapi.NewMessage(message.From.ID, verificationNotFound)
 		msg.ParseMode = tgbotapi.ModeHTML
 		msg.ReplyMarkup = contactUsKeyboard
-		_, err2 := t.bot.Send(msg)
+		_, err2 := t.send(msg)
 		if err2 != nil {
 			return err2
 		}
 		return err
 	}
 
-	if verification.Status == verified {
-		_, err = t.bot.Send(tgbotapi.NewMessage(message.From.ID, verificationCompleted))
+	if session.Verification.Status == verified {
+		_, err = t.send(tgbotapi.NewMessage(message.From.ID, verificationCompleted))
 		return err
 	}
 
-	_, err = t.bot.Send(tgbotapi.NewMessage(message.From.ID, startVerificationInit))
+	_, err = t.send(tgbotapi.NewMessage(message.From.ID, startVerificationInit))
 	if err != nil {
 		return err
 	}
 
-	return t.nextCheck(message.From.ID, verification)
+	return t.nextCheck(message.From.ID, session)
 }
 
+// ParseText answers free text with the LLM in expert mode, and with help on the current verification step otherwise.
 func (t *TelegramBot) ParseText(ctx context.Context, message *tgbotapi.Message) error {
-	if t.gptClient == nil {
-		msg := tgbotapi.NewMessage(message.From.ID, helpText)
-		msg.ParseMode = tgbotapi.ModeHTML
-		_, err := t.bot.Send(msg)
-		return err
-	}
-	resp, err := t.gptClient.Send(ctx, &chatgpt.ChatCompletionRequest{
-		Model: chatgpt.GPT35Turbo,
-		Messages: []chatgpt.ChatMessage{
-			{
-				Role:    chatgpt.ChatGPTModelRoleSystem,
-				Content: t.prompt,
-			},
-			{
-				Role:    chatgpt.ChatGPTModelRoleUser,
-				Content: message.Text,
-			},
-		},
-	})
-	if err != nil {
-		return err
+	active, expired := t.expert.touch(message.From.ID, time.Now(), t.expertTimeout)
+	if active && t.llm != nil {
+		return t.askExpert(ctx, message)
+	}
+	if expired {
+		err := t.sendHTML(message.From.ID, expertModeTimedOut, nil)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err = t.bot.Send(tgbotapi.NewMessage(message.From.ID, resp.Choices[0].Message.Content))
-	return err
+	return t.stepHelp(ctx, message.From.ID)
 }
 
 func (t *TelegramBot) getLink(message *tgbotapi.Message) (string, string, error) {
 	switch {
 	case message.Document != nil:
+		err := t.checkFileSize(message.Document.FileSize)
+		if err != nil {
+			return "", "", err
+		}
 		doc, err := t.bot.GetFile(tgbotapi.FileConfig{FileID: message.Document.FileID})
 		if err != nil {
 			return "", "", err
@@ -351,6 +482,10 @@ func (t *TelegramBot) getLink(message *tgbotapi.Message) (string, string, error)
 			}
 		}
 
+		err := t.checkFileSize(maxSize)
+		if err != nil {
+			return "", "", err
+		}
 		f, err := t.bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
 		if err != nil {
 			// TODO: logging
@@ -364,127 +499,126 @@ func (t *TelegramBot) getLink(message *tgbotapi.Message) (string, string, error)
 }
 
 func (t *TelegramBot) ParseDocument(ctx context.Context, message *tgbotapi.Message) error {
+	defer t.deleteIncoming(message)
+
 	tgID := strconv.FormatInt(message.From.ID, 10)
-	verification, err := t.cache.GetVerification(ctx, tgID)
+	session, err := t.cache.GetSession(ctx, tgID)
 	if err != nil {
 		return err
 	}
 
 	filename, url, err := t.getLink(message)
 	if err != nil {
-		return err
+		return t.uploadFailed(ctx, message.From.ID, session, err)
 	}
 
-	defer t.bot.Send(tgbotapi.NewDeleteMessage(message.From.ID, message.MessageID))
+	st := stepFor(session.State)
+	if st.choice {
+		return t.sendHTML(message.From.ID, poiChooseFirst, nil)
+	}
+	docType := st.docType
+	if docType == "" {
+		return errors.New("status not supported for upload document")
+	}
+	if t.attemptsLeft(session) <= 0 {
+		return t.sendHTML(message.From.ID, uploadAttemptsExhausted, contactUsKeyboard)
+	}
 
-	// Get the data
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	doc, err := t.uploadDocument(ctx, docType, uploadSide(session.State), filename, url, session)
 	if err != nil {
-		return err
+		return t.uploadFailed(ctx, message.From.ID, session, err)
 	}
-	resp, err := t.httpClient.Do(req)
+
+	return t.documentUploaded(ctx, message.From.ID, docType, session, doc)
+}
+
+// uploadDocument downloads the file from telegram and uploads it to dataspike as the side of the document,
+// dataspike checks the side unless it is empty.
+func (t *TelegramBot) uploadDocument(ctx context.Context, docType, side, filename, url string, session *flow.Session) (*dataspike.Document, error) {
+	file, err := t.fetchDocument(ctx, session, filename, url)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	switch {
-	case verification.Checks.DocumentMrz != nil:
-		verification.Checks.DocumentMrz = nil
-		return t.uploadDocument(ctx, message.From.ID, Poi, filename, verification, resp.Body)
-	case verification.Checks.FaceComparison != nil:
-		verification.Checks.FaceComparison = nil
-		return t.uploadDocument(ctx, message.From.ID, Selfie, filename, verification, resp.Body)
-	case verification.Checks.Poa != nil:
-		verification.Checks.Poa = nil
-		return t.uploadDocument(ctx, message.From.ID, Poa, filename, verification, resp.Body)
-	default:
-		return errors.New("status not supported for upload document")
+	return t.uploadPrepared(docType, side, file, session)
+}
+
+// fetchDocument downloads the file from telegram, checks it and prepares it for the upload.
+func (t *TelegramBot) fetchDocument(ctx context.Context, session *flow.Session, filename, url string) (*preparedFile, error) {
+	file, err := t.download(ctx, url)
+	if err != nil {
+		return nil, err
 	}
+
+	filename, err = t.checkFileType(session.State, filename, file.data)
+	if err != nil {
+		return nil, err
+	}
+	prepared, err := t.prepareImage(session, filename, file.data)
+	if err != nil {
+		return nil, err
+	}
+	prepared.sha256 = file.sha256
+
+	return prepared, nil
 }
 
-func (t *TelegramBot) uploadDocument(ctx context.Context, tgID int64, docType, filename string, verification *dataspike.Verification, file io.Reader) error {
-	respDoc, err := t.dsClient.UploadDocument(&dataspike.DocumentUpload{
+// uploadPrepared uploads the file to dataspike and records the upload in the session.
+func (t *TelegramBot) uploadPrepared(docType, side string, file *preparedFile, session *flow.Session) (*dataspike.Document, error) {
+	filename := file.filename
+	if docType == Poa && session.PoaCategory != "" {
+		// dataspike has no field for the category, it is kept with the document in its name
+		filename = session.PoaCategory + "_" + filename
+	}
+	upload := &dataspike.DocumentUpload{
 		DocType:     docType,
 		FileName:    filename,
-		ApplicantID: verification.ApplicantID,
-		Reader:      file,
-	})
-	if err != nil {
-		return err
+		ApplicantID: session.Verification.ApplicantID,
+		Reader:      bytes.NewReader(file.data),
 	}
-	if respDoc.Errors != nil {
-		return errors.New(respDoc.Errors.String())
+	if side != "" {
+		upload.Side = &side
 	}
-
-	if docType == Poi && respDoc.DetectedTwoSideDocument != nil && *respDoc.DetectedTwoSideDocument &&
-		respDoc.DetectedDocumentSide != nil && *respDoc.DetectedDocumentSide == Front {
-		msg := tgbotapi.NewMessage(tgID, AttachBackSideOfDoc)
-		msg.ParseMode = tgbotapi.ModeHTML
-		_, err = t.bot.Send(msg)
-		return err
+	if docType == Poi && session.IssuedCountry != "" {
+		upload.IssuedCountry = &session.IssuedCountry
 	}
 
-	err = t.cache.SetVerification(ctx, strconv.FormatInt(tgID, 10), verification)
+	respDoc, err := t.dsClient.UploadDocument(upload)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if respDoc.Errors != nil {
+		return nil, &rejectedUploadError{errors: respDoc.Errors}
 	}
 
-	return t.nextCheck(tgID, verification)
-}
-
-func (t *TelegramBot) nextCheck(chatID int64, verification *dataspike.Verification) error {
-	msg := tgbotapi.NewMessage(chatID, "")
-	switch {
-	case verification.Checks.DocumentMrz != nil && verification.Checks.DocumentMrz.Status == pending:
-		msg.Text = poiHelpForButton
-		msg.ReplyMarkup = mzrKeyboard
-		_, err := t.bot.Send(tgbotapi.NewMessage(chatID, poiAttachDocument))
-		if err != nil {
-			return err
-		}
-	case verification.Checks.Liveness != nil && verification.Checks.Liveness.Status == pending:
-		msg.Text = LivenessPrompt
-		msg.ReplyMarkup = generateLivenessKeyboard(fmt.Sprintf("%s?source=telegram&botName=%s", verification.VerificationUrl, t.bot.Self.UserName))
-	case verification.Checks.FaceComparison != nil && verification.Checks.FaceComparison.Status == pending:
-		msg.Text = AttachSelfiePrompt
-	case verification.Checks.Poa != nil && verification.Checks.Poa.Status == pending:
-		if verification.Settings.PoaRequired {
-			msg.Text = SelectedPoaDocument
-		} else {
-			msg.Text = PoaSkipPrompt
-			msg.ReplyMarkup = skipPoaKeyboard
-			_, err := t.bot.Send(tgbotapi.NewMessage(chatID, SelectedPoaDocument))
-			if err != nil {
-				return err
-			}
-		}
-	default:
-		msg.Text = VerificationStartedPleaseWait
-		err := t.dsClient.ProceedVerification(verification.VerificationUrlId)
-		if err != nil {
-			return err
-		}
+	record := flow.Upload{DocumentID: respDoc.DocumentId, DocType: docType, State: session.State, SHA256: file.sha256, PHash: uint64(file.phash), At: time.Now()}
+	if docType == Poa {
+		record.Category = session.PoaCategory
 	}
+	session.Uploads = append(session.Uploads, record)
 
-	_, err := t.bot.Send(msg)
-	return err
+	return respDoc, nil
 }
 
-func (t *TelegramBot) SendVerificationStatus(ctx context.Context, applicantID, status string) error {
-	applicant, err := t.dsClient.GetApplicantByID(uuid.FromStringOrNil(applicantID))
-	if err != nil {
-		// TODO: logging
-		return err
+// documentUploaded moves the session past the step the document was uploaded for.
+func (t *TelegramBot) documentUploaded(ctx context.Context, chatID int64, docType string, session *flow.Session, doc *dataspike.Document) error {
+	switch docType {
+	case Poi:
+		return t.poiUploaded(ctx, chatID, session, doc)
+	case Poa:
+		return t.poaPagesUploaded(ctx, chatID, session)
 	}
 
-	v, err := t.cache.GetVerification(ctx, applicant.TgProfile)
+	err := t.fire(ctx, strconv.FormatInt(chatID, 10), session, flow.EventUploaded)
 	if err != nil {
-		// TODO: logging
 		return err
 	}
-	v.Status = status
-	err = t.cache.SetVerification(ctx, applicant.TgProfile, v)
+
+	return t.nextCheck(chatID, session)
+}
+
+func (t *TelegramBot) SendVerificationStatus(ctx context.Context, applicantID, status string) error {
+	applicant, err := t.dsClient.GetApplicantByID(uuid.FromStringOrNil(applicantID))
 	if err != nil {
 		// TODO: logging
 		return err
@@ -496,24 +630,44 @@ func (t *TelegramBot) SendVerificationStatus(ctx context.Context, applicantID, s
 		return err
 	}
 
+	return t.chats.wait(tgID, func() error {
+		return t.verificationStatus(ctx, applicant.TgProfile, tgID, status)
+	})
+}
+
+func (t *TelegramBot) verificationStatus(ctx context.Context, tgProfile string, tgID int64, status string) error {
 	if status != verified {
-		err = t.cache.RemoveVerification(ctx, applicant.TgProfile)
+		err := t.cache.RemoveSession(ctx, tgProfile)
 		if err != nil {
 			// TODO: logging
 			return err
 		}
+		t.forgetReminders(ctx, tgProfile)
+		t.log().Info("verification rejected", "tg_id", tgProfile, "status", status)
+
 		msg := tgbotapi.NewMessage(tgID, VerificationFailed)
 		msg.ParseMode = tgbotapi.ModeHTML
 		msg.ReplyMarkup = contactUsKeyboard
-		_, err = t.bot.Send(msg)
+		_, err = t.send(msg)
 		return err
 	}
 
-	_, err = t.bot.Send(tgbotapi.NewMessage(tgID, VerificationOk))
-	return err
+	_, err := t.send(tgbotapi.NewMessage(tgID, VerificationOk))
+	if err != nil {
+		return err
+	}
+
+	session, err := t.cache.GetSession(ctx, tgProfile)
+	if err != nil {
+		// TODO: logging
+		return err
+	}
+	session.Verification.Status = status
+
+	return t.fire(ctx, tgProfile, session, flow.EventVerified)
 }
 
-func (t *TelegramBot) CheckLiveness(ctx context.Context, applicantId, status string) error {
+func (t *TelegramBot) CheckLiveness(ctx context.Context, applicantId, status string, reasons dataspike.Errors) error {
 	applicant, err := t.dsClient.GetApplicantByID(uuid.FromStringOrNil(applicantId))
 	if err != nil {
 		// TODO: logging
@@ -526,34 +680,29 @@ func (t *TelegramBot) CheckLiveness(ctx context.Context, applicantId, status str
 		return err
 	}
 
-	v, err := t.cache.GetVerification(ctx, applicant.TgProfile)
+	return t.chats.wait(tgID, func() error {
+		return t.livenessChecked(ctx, applicant.TgProfile, tgID, status, reasons)
+	})
+}
+
+func (t *TelegramBot) livenessChecked(ctx context.Context, tgProfile string, tgID int64, status string, reasons dataspike.Errors) error {
+	session, err := t.cache.GetSession(ctx, tgProfile)
 	if err != nil {
 		// TODO: logging
 		return err
 	}
 
 	if status != verified {
-		err = t.cache.RemoveVerification(ctx, applicant.TgProfile)
-		if err != nil {
-			// TODO: logging
-			return err
-		}
-		msg := tgbotapi.NewMessage(tgID, LivenessFailed)
-		msg.ParseMode = tgbotapi.ModeHTML
-		msg.ReplyMarkup = contactUsKeyboard
-		_, err = t.bot.Send(msg)
-		return err
+		return t.livenessFailed(ctx, tgID, session, reasons)
 	}
 
-	v.Checks.Liveness = nil
-	v.Checks.FaceComparison = nil
-	err = t.cache.SetVerification(ctx, applicant.TgProfile, v)
+	err = t.fire(ctx, tgProfile, session, flow.EventLivenessPassed)
 	if err != nil {
 		// TODO: logging
 		return err
 	}
 
-	return t.nextCheck(tgID, v)
+	return t.nextCheck(tgID, session)
 }
 
 // WithBuffer is a Option that allows you set size of bot buffer.
@@ -564,25 +713,192 @@ func WithBuffer(buffer int) Option {
 	}
 }
 
-// WithGPT is a Option that allows you using chatgpt client for answer to text messages.
+// WithGPT is a Option that allows you using LLM, e.g. OpenAI, for answer to text messages.
 // When this option is nil, bot will answer default message.
-func WithGPT(client *chatgpt.Client, prompt string) Option {
+func WithGPT(llm LLM, prompt string) Option {
 	return func(t *TelegramBot) {
-		t.gptClient = client
+		t.llm = llm
 		if prompt != "" {
 			t.prompt = prompt
 		}
 	}
 }
 
-// WithSandbox is a Option add create_verification command.
+// WithSandbox is a Option that enables sandbox commands, e.g. create_verification.
 func WithSandbox() Option {
 	return func(t *TelegramBot) {
 		t.dev = true
-		menu.Commands = append(menu.Commands, tgbotapi.BotCommand{
-			Command:     "/create_verification",
-			Description: "Create new verification",
-		})
+	}
+}
+
+// WithAdmins is a Option that allows you set chats which can see and use admin commands.
+func WithAdmins(tgIDs ...int64) Option {
+	return func(t *TelegramBot) {
+		t.admins = append(t.admins, tgIDs...)
+	}
+}
+
+// WithCommands is a Option that allows you register additional commands.
+// A command with the same name as a built-in one replaces it.
+func WithCommands(commands ...Command) Option {
+	return func(t *TelegramBot) {
+		t.commands.register(commands...)
+	}
+}
+
+// WithMenuStore is a Option that allows you remember the published command menus, so the menus of admins
+// and languages which are no longer configured are deleted. Use NewMenuFileStore for them to survive restarts.
+func WithMenuStore(store IMenuStore) Option {
+	return func(t *TelegramBot) {
+		t.menus = store
+	}
+}
+
+// WithAlbumWindow is a Option that allows you set how long the bot waits for the rest of
+// a media group after its first photo. Default value is 1 second.
+func WithAlbumWindow(window time.Duration) Option {
+	return func(t *TelegramBot) {
+		t.albumWindow = window
+	}
+}
+
+// WithRetryBudget is a Option that allows you set how many rejected uploads (or failed liveness checks
+// for flow.StateLiveness) are allowed in the step before the user is referred to the support. Default value is 3.
+func WithRetryBudget(state flow.State, attempts int) Option {
+	return func(t *TelegramBot) {
+		if t.retryBudgets == nil {
+			t.retryBudgets = make(map[flow.State]int)
+		}
+		t.retryBudgets[state] = attempts
+	}
+}
+
+// WithQualityThresholds is a Option that allows you set the limits photos uploaded in the step are checked
+// against before they are sent to dataspike. Default value is imaging.DefaultThresholds,
+// imaging.Thresholds{} disables the checks.
+func WithQualityThresholds(state flow.State, thresholds imaging.Thresholds) Option {
+	return func(t *TelegramBot) {
+		if t.thresholds == nil {
+			t.thresholds = make(map[flow.State]imaging.Thresholds)
+		}
+		t.thresholds[state] = thresholds
+	}
+}
+
+// WithFileTypes is a Option that allows you set the MIME types of files accepted in the step. The type is
+// detected from the content of the file. By default photos are accepted, and PDF documents in flow.StatePoa.
+func WithFileTypes(state flow.State, types ...string) Option {
+	return func(t *TelegramBot) {
+		if t.fileTypes == nil {
+			t.fileTypes = make(map[flow.State][]string)
+		}
+		t.fileTypes[state] = types
+	}
+}
+
+// WithMaxFileSize is a Option that allows you set the largest file in bytes users can upload.
+// Default value is 20 MB, the limit of the Bot API.
+func WithMaxFileSize(size int) Option {
+	return func(t *TelegramBot) {
+		t.maxFileSize = size
+	}
+}
+
+// WithDownloadRetries is a Option that allows you set how many times a file is downloaded from telegram
+// before the upload fails, and the timeout of every attempt. Default values are 3 attempts of 30 seconds.
+func WithDownloadRetries(attempts int, timeout time.Duration) Option {
+	return func(t *TelegramBot) {
+		t.downloadAttempts = attempts
+		t.downloadTimeout = timeout
+	}
+}
+
+// WithMaxImageEdge is a Option that allows you set the longest edge photos are downscaled to before
+// the upload. Default value is imaging.DefaultMaxEdge, values below imaging.MinMaxEdge are raised to it.
+func WithMaxImageEdge(edge int) Option {
+	return func(t *TelegramBot) {
+		t.maxImageEdge = edge
+	}
+}
+
+// WithReminders is a Option that allows you remind users about unfinished verifications: idle after the last
+// activity in the session and beforeExpiry before the verification expires, zero disables the reminder.
+// Use reminder.NewFileStore for reminders to survive restarts. The sessions are kept by the cache,
+// so the reminders of the sessions a restart has lost are dropped, see the reminders_dropped metric.
+func WithReminders(store reminder.Store, idle, beforeExpiry time.Duration, options ...reminder.Option) Option {
+	return func(t *TelegramBot) {
+		options = append([]reminder.Option{reminder.WithErrorHandler(func(job reminder.Job, err error) {
+			t.log().Warn("reminder failed", "tg_id", job.TgID, "kind", job.Kind, "error", err)
+		})}, options...)
+		t.reminders = reminder.NewScheduler(store, t.remind, options...)
+		t.idleReminder = idle
+		t.expiryReminder = beforeExpiry
+	}
+}
+
+// WithMessageRetention is a Option that allows you delete the messages of the conversation, sent by
+// the bot and by the user, the window after they have been sent. Windows longer than telegram allows
+// to delete messages in are shortened to fit it. Use retention.NewFileStore for messages to be deleted after restarts.
+func WithMessageRetention(store retention.Store, window time.Duration, options ...retention.Option) Option {
+	return func(t *TelegramBot) {
+		options = append([]retention.Option{retention.WithFailureHandler(t.messageNotDeleted)}, options...)
+		t.janitor = retention.NewJanitor(store, t.deleteMessage, window, options...)
+	}
+}
+
+// WithExpertTimeout is a Option that allows you set how long expert mode lasts after the last question.
+// Default value is 10 minutes.
+func WithExpertTimeout(timeout time.Duration) Option {
+	return func(t *TelegramBot) {
+		if timeout > 0 {
+			t.expertTimeout = timeout
+		}
+	}
+}
+
+// WithOutputPolicy is a Option that allows you limit what the answers of the LLM may contain: answers
+// mentioning a blocked topic aren't sent, and links to domains which aren't allowed are removed.
+// By default only the links to dataspike.io are allowed.
+func WithOutputPolicy(policy OutputPolicy) Option {
+	return func(t *TelegramBot) {
+		t.output = newOutputFilter(policy)
+	}
+}
+
+// WithKnowledgeBase is a Option that allows you ground the answers of the expert in the knowledge base:
+// up to limit passages relevant to the question are added to the prompt, and the answer lists the ones it cites.
+// Default limit is 3.
+func WithKnowledgeBase(kb KnowledgeBase, limit int) Option {
+	return func(t *TelegramBot) {
+		t.knowledge = kb
+		t.knowledgeLimit = limit
+		if limit <= 0 {
+			t.knowledgeLimit = defaultKnowledgeLimit
+		}
+	}
+}
+
+// WithExpertMemory is a Option that allows you keep the recent questions and answers of every user,
+// so the LLM answers follow-up questions in context. By default every question is answered on its own.
+func WithExpertMemory(store conversation.Store, options ...conversation.Option) Option {
+	return func(t *TelegramBot) {
+		t.expertMemory = conversation.NewMemory(store, options...)
+	}
+}
+
+// WithSecrets is a Option that allows you set secrets, e.g. the dataspike token, which must never appear
+// in errors and logs. The bot token is always redacted.
+func WithSecrets(secrets ...string) Option {
+	return func(t *TelegramBot) {
+		t.secrets = append(t.secrets, secrets...)
+	}
+}
+
+// WithLogger is a Option that allows you set logger for the bot events.
+// By default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(t *TelegramBot) {
+		t.logger = logger
 	}
 }
 
@@ -593,22 +909,50 @@ func WithHTTPClient(client IHTTPClient) Option {
 	}
 }
 
+// updateFailed logs the error of handling an update, the logger redacts the secrets it may contain.
+func (t *TelegramBot) updateFailed(update string, chatID int64, err error) {
+	t.log().Error("update handling failed", "update", update, "chat_id", chatID, "error", err)
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func (t *TelegramBot) log() *slog.Logger {
+	if t.logger == nil {
+		return discardLogger
+	}
+
+	return t.logger
+}
+
 func NewTelegramBot(bot *tgbotapi.BotAPI, dsClient dataspike.IDataspikeClient, cache ICache, options ...Option) (ITelegramBot, error) {
 	dsTgBot := &TelegramBot{
-		bot:        bot,
-		dsClient:   dsClient,
-		cache:      cache,
-		httpClient: http.DefaultClient,
+		bot:           bot,
+		dsClient:      dsClient,
+		cache:         cache,
+		httpClient:    http.DefaultClient,
+		expertTimeout: defaultExpertTimeout,
 		prompt: `You are a helpful KYC assistant created by dataspike.io.
 You're limited to respond only to requests that belong to KYC and AML domain 
 and all other requests should be politely rejected as not fitting your work responsibilities.`,
 	}
 
+	dsTgBot.commands = newCommandRegistry(dsTgBot.defaultCommands()...)
+
 	for _, o := range options {
 		o(dsTgBot)
 	}
 
-	_, err := bot.Request(menu)
+	// the bot token is a part of the file links, errors of the downloads contain it
+	dsTgBot.redactor = redact.New(append(dsTgBot.secrets, bot.Token)...)
+	if dsTgBot.logger != nil {
+		dsTgBot.logger = slog.New(dsTgBot.redactor.Handler(dsTgBot.logger.Handler()))
+	}
+
+	if dsTgBot.commands.err != nil {
+		return nil, dsTgBot.commands.err
+	}
+
+	err := dsTgBot.syncCommands()
 	if err != nil {
 		return nil, err
 	}
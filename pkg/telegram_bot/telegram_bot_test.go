@@ -3,18 +3,39 @@ package telegram_bot
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"github.com/ayush6624/go-chatgpt"
+	"expvar"
+	"fmt"
 	dataspike "github.com/dataspike-io/docver-sdk-go"
+	"github.com/dataspike-io/docver-tg-bot/pkg/conversation"
+	"github.com/dataspike-io/docver-tg-bot/pkg/flow"
+	"github.com/dataspike-io/docver-tg-bot/pkg/imaging"
+	"github.com/dataspike-io/docver-tg-bot/pkg/knowledge"
+	"github.com/dataspike-io/docver-tg-bot/pkg/redact"
+	"github.com/dataspike-io/docver-tg-bot/pkg/reminder"
+	"github.com/dataspike-io/docver-tg-bot/pkg/retention"
 	"github.com/dataspike-io/docver-tg-bot/pkg/telegram_bot/mocks"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/gofrs/uuid"
 	"github.com/golang/mock/gomock"
+	"hash/crc32"
+	"image"
+	"image/png"
 	"io"
+	"log/slog"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -57,6 +78,12 @@ func TestNewTelegramBot(t *testing.T) {
 			},
 			err: errors.New("request failed"),
 		},
+		{
+			name: "command without handler",
+			args: args{telegramToken: "123", options: []Option{WithCommands(Command{Name: "stats", Description: "Stats"})}},
+			f:    func() {},
+			err:  errors.New(`command "stats" has no handler`),
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -68,6 +95,55 @@ func TestNewTelegramBot(t *testing.T) {
 	}
 }
 
+// thumbnail is a PNG image too small to be used for verification.
+func thumbnail(t *testing.T) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 90, 60)))
+	if err != nil {
+		t.Fatalf("error encoding image: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+// photo is a PNG image good enough to be used for verification.
+func photo(t *testing.T) []byte {
+	return photoOf(t, 1)
+}
+
+// oversizedPhoto is a tiny PNG whose header claims a huge image.
+func oversizedPhoto(t *testing.T) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatalf("error encoding image: %s", err)
+	}
+	data := buf.Bytes()
+	// width and height of the IHDR chunk, followed by its CRC of type and data
+	binary.BigEndian.PutUint32(data[16:], 100_000)
+	binary.BigEndian.PutUint32(data[20:], 100_000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	return data
+}
+
+// photoOf is a photo of its own for every seed.
+func photoOf(t *testing.T, seed int64) []byte {
+	img := image.NewGray(image.Rect(0, 0, 800, 600))
+	rnd := rand.New(rand.NewSource(seed))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(60 + rnd.Intn(120))
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatalf("error encoding image: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
 func newBot(httpMock *mock_telegram_bot.MockIHTTPClient) (*tgbotapi.BotAPI, error) {
 	apiResp := tgbotapi.APIResponse{Ok: true, Result: json.RawMessage(`{"id":123}`)}
 	b, err := json.Marshal(&apiResp)
@@ -101,6 +177,7 @@ func Test_telegramBot_CheckLiveness(t *testing.T) {
 	type args struct {
 		applicantID string
 		status      string
+		reasons     dataspike.Errors
 	}
 	tests := []struct {
 		name string
@@ -110,11 +187,11 @@ func Test_telegramBot_CheckLiveness(t *testing.T) {
 	}{
 		{
 			name: "verified",
-			args: args{"test", verified},
+			args: args{"test", verified, nil},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateLiveness}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("next check failed"))
 			},
@@ -122,47 +199,62 @@ func Test_telegramBot_CheckLiveness(t *testing.T) {
 		},
 		{
 			name: "unverified",
-			args: args{"test", "failed"},
+			args: args{"test", "failed", dataspike.Errors{{Code: 1, Message: "face not found"}}},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().RemoveVerification(gomock.Any(), gomock.Eq("123")).Return(nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateLiveness}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StateLiveness, s.State)
+					assert.Equal(t, map[flow.State]int{flow.StateLiveness: 1}, s.Attempts)
+					return nil
+				})
 				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("liveness failed"))
 			},
 			err: errors.New("liveness failed"),
 		},
+		{
+			name: "unverified attempts exhausted",
+			args: args{"test", "failed", nil},
+			f: func() {
+				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateLiveness, Attempts: map[flow.State]int{flow.StateLiveness: defaultRetryBudget - 1}}, nil)
+				cacheMock.EXPECT().RemoveSession(gomock.Any(), gomock.Eq("123")).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
 		{
 			name: "set verification error",
-			args: args{"test", verified},
+			args: args{"test", verified, nil},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateLiveness}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 			},
 			err: errors.New("set verification error"),
 		},
 		{
 			name: "remove verification error",
-			args: args{"test", "failed"},
+			args: args{"test", "failed", nil},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().RemoveVerification(gomock.Any(), gomock.Eq("123")).Return(errors.New("remove verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateLiveness, Attempts: map[flow.State]int{flow.StateLiveness: defaultRetryBudget - 1}}, nil)
+				cacheMock.EXPECT().RemoveSession(gomock.Any(), gomock.Eq("123")).Return(errors.New("remove verification error"))
 			},
 			err: errors.New("remove verification error"),
 		},
 		{
 			name: "get verification error",
-			args: args{"test", "failed"},
+			args: args{"test", "failed", nil},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(nil, errors.New("get verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(nil, errors.New("get verification error"))
 			},
 			err: errors.New("get verification error"),
 		},
 		{
 			name: "get applicant error",
-			args: args{"test", "failed"},
+			args: args{"test", "failed", nil},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(nil, errors.New("get applicant error"))
 			},
@@ -170,7 +262,7 @@ func Test_telegramBot_CheckLiveness(t *testing.T) {
 		},
 		{
 			name: "parse tgBotID error",
-			args: args{"test", "failed"},
+			args: args{"test", "failed", nil},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "abc"}, nil)
 			},
@@ -181,7 +273,7 @@ func Test_telegramBot_CheckLiveness(t *testing.T) {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			tt.f()
-			err = tBot.CheckLiveness(ctx, tt.args.applicantID, tt.args.status)
+			err = tBot.CheckLiveness(ctx, tt.args.applicantID, tt.args.status, tt.args.reasons)
 			assert.Equal(t, tt.err, err)
 		})
 	}
@@ -221,8 +313,8 @@ func Test_telegramBot_SendVerificationStatus(t *testing.T) {
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 			},
 			err: nil,
 		},
@@ -231,7 +323,7 @@ func Test_telegramBot_SendVerificationStatus(t *testing.T) {
 			args: args{"test", "failed"},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
-				cacheMock.EXPECT().RemoveVerification(gomock.Any(), gomock.Eq("123")).Return(nil)
+				cacheMock.EXPECT().RemoveSession(gomock.Any(), gomock.Eq("123")).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("verification failed"))
 			},
 			err: errors.New("verification failed"),
@@ -241,7 +333,7 @@ func Test_telegramBot_SendVerificationStatus(t *testing.T) {
 			args: args{"test", "failed"},
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
-				cacheMock.EXPECT().RemoveVerification(gomock.Any(), gomock.Eq("123")).Return(errors.New("remove verification error"))
+				cacheMock.EXPECT().RemoveSession(gomock.Any(), gomock.Eq("123")).Return(errors.New("remove verification error"))
 			},
 			err: errors.New("remove verification error"),
 		},
@@ -251,8 +343,8 @@ func Test_telegramBot_SendVerificationStatus(t *testing.T) {
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 			},
 			err: errors.New("set verification error"),
 		},
@@ -262,7 +354,7 @@ func Test_telegramBot_SendVerificationStatus(t *testing.T) {
 			f: func() {
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{TgProfile: "123"}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, errors.New("get verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(nil, errors.New("get verification error"))
 			},
 			err: errors.New("get verification error"),
 		},
@@ -378,27 +470,67 @@ func Test_telegramBot_callbackParse(t *testing.T) {
 	}{
 		{
 			name: learnMrz,
-			args: args{&tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 123}, Data: learnMrz}},
+			args: args{&tgbotapi.CallbackQuery{ID: "1", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: 123}}, Data: learnMrz}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "duplicate press",
+			args: args{&tgbotapi.CallbackQuery{ID: "2", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: 123}}, Data: learnMrz}},
 			f: func() {
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "answer callback error",
+			args: args{&tgbotapi.CallbackQuery{ID: "3", From: &tgbotapi.User{ID: 123}, Data: learnMrz}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("answer callback error"))
+			},
+			err: errors.New("answer callback error"),
+		},
+		{
+			name: "edit message error",
+			args: args{&tgbotapi.CallbackQuery{ID: "4", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 2, Chat: &tgbotapi.Chat{ID: 123}}, Data: learnMrz}},
+			f: func() {
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("edit message error"))
+			},
+			err: errors.New("edit message error"),
+		},
+		{
+			name: "retry after error",
+			args: args{&tgbotapi.CallbackQuery{ID: "4a", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 2, Chat: &tgbotapi.Chat{ID: 123}}, Data: learnMrz}},
+			f: func() {
+				for i := 0; i < 4; i++ {
+					httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				}
 			},
 			err: nil,
 		},
 		{
 			name: "send message error",
-			args: args{&tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 123}, Data: learnMrz}},
+			args: args{&tgbotapi.CallbackQuery{ID: "5", From: &tgbotapi.User{ID: 123}, Data: learnMrz}},
 			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
 			},
 			err: errors.New("send message error"),
 		},
 		{
 			name: skipPoa,
-			args: args{&tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 123}, Data: skipPoa}},
+			args: args{&tgbotapi.CallbackQuery{ID: "6", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 3, Chat: &tgbotapi.Chat{ID: 123}}, Data: skipPoa}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
@@ -406,26 +538,254 @@ func Test_telegramBot_callbackParse(t *testing.T) {
 		},
 		{
 			name: "set verification error",
-			args: args{&tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 123}, Data: skipPoa}},
+			args: args{&tgbotapi.CallbackQuery{ID: "7", From: &tgbotapi.User{ID: 123}, Data: skipPoa}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 			},
 			err: errors.New("set verification error"),
 		},
 		{
 			name: "get verification error",
-			args: args{&tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 123}, Data: skipPoa}},
+			args: args{&tgbotapi.CallbackQuery{ID: "8", From: &tgbotapi.User{ID: 123}, Data: skipPoa}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Any()).Return(&dataspike.Verification{}, errors.New("get verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(nil, errors.New("get verification error"))
 			},
 			err: errors.New("get verification error"),
 		},
+		{
+			name: "skip poa after pages",
+			args: args{&tgbotapi.CallbackQuery{ID: "8a", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 8, Chat: &tgbotapi.Chat{ID: 123}}, Data: skipPoa}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa, Uploads: []flow.Upload{{DocType: Poa, State: flow.StatePoa}}}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, poaSkipUnavailable, req.FormValue("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.Equal(t, "editMessageReplyMarkup", path.Base(req.URL.Path))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+			},
+			err: nil,
+		},
+		{
+			name: "skip required poa",
+			args: args{&tgbotapi.CallbackQuery{ID: "8b", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 25, Chat: &tgbotapi.Chat{ID: 123}}, Data: skipPoa}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{PoaRequired: true}}, State: flow.StatePoa}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, buttonUnavailable, req.FormValue("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.Equal(t, "editMessageReplyMarkup", path.Base(req.URL.Path))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+			},
+			err: nil,
+		},
+		{
+			name: "skip poa stale button",
+			args: args{&tgbotapi.CallbackQuery{ID: "8c", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 26, Chat: &tgbotapi.Chat{ID: 123}}, Data: skipPoa}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Any()).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, buttonUnavailable, req.FormValue("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.Equal(t, "editMessageReplyMarkup", path.Base(req.URL.Path))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+			},
+			err: nil,
+		},
+		{
+			name: retakeFront,
+			args: args{&tgbotapi.CallbackQuery{ID: "10", From: &tgbotapi.User{ID: 123}, Data: retakeFront}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiBack}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "retake back unavailable",
+			args: args{&tgbotapi.CallbackQuery{ID: "11", From: &tgbotapi.User{ID: 123}, Data: retakeBack}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "document type",
+			args: args{&tgbotapi.CallbackQuery{ID: "12", From: &tgbotapi.User{ID: 123}, Data: "doc_type:id_card"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{Countries: []string{"DE", "FR"}}}, State: flow.StatePoiType}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoiCountry, s.State)
+					assert.Equal(t, "id_card", s.DocumentType)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "document type with single country",
+			args: args{&tgbotapi.CallbackQuery{ID: "13", From: &tgbotapi.User{ID: 123}, Data: "doc_type:passport"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{Countries: []string{"DE"}}}, State: flow.StatePoiType}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoiFront, s.State)
+					assert.Equal(t, "DE", s.IssuedCountry)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "undefined document type",
+			args: args{&tgbotapi.CallbackQuery{ID: "14", From: &tgbotapi.User{ID: 123}, Data: "doc_type:visa"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("undefined document type"),
+		},
+		{
+			name: "issued country",
+			args: args{&tgbotapi.CallbackQuery{ID: "15", From: &tgbotapi.User{ID: 123}, Data: "country:FR"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiCountry, DocumentType: "passport"}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoiFront, s.State)
+					assert.Equal(t, "FR", s.IssuedCountry)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "issued country unavailable",
+			args: args{&tgbotapi.CallbackQuery{ID: "16", From: &tgbotapi.User{ID: 123}, Data: "country:"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateSelfie}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "poa category",
+			args: args{&tgbotapi.CallbackQuery{ID: "17", From: &tgbotapi.User{ID: 123}, Data: "poa_category:utility_bill"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoaCategory}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoa, s.State)
+					assert.Equal(t, "utility_bill", s.PoaCategory)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "undefined poa category",
+			args: args{&tgbotapi.CallbackQuery{ID: "18", From: &tgbotapi.User{ID: 123}, Data: "poa_category:lease"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("undefined poa category"),
+		},
+		{
+			name: poaDone,
+			args: args{&tgbotapi.CallbackQuery{ID: "19", From: &tgbotapi.User{ID: 123}, Data: poaDone}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa, Uploads: []flow.Upload{{State: flow.StatePoa}}}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "poa done without pages",
+			args: args{&tgbotapi.CallbackQuery{ID: "20", From: &tgbotapi.User{ID: 123}, Data: poaDone}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: statusResume,
+			args: args{&tgbotapi.CallbackQuery{ID: "21", From: &tgbotapi.User{ID: 123}, Data: statusResume}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateSelfie}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "resume in review",
+			args: args{&tgbotapi.CallbackQuery{ID: "22", From: &tgbotapi.User{ID: 123}, Data: statusResume}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: statusCancel,
+			args: args{&tgbotapi.CallbackQuery{ID: "23", From: &tgbotapi.User{ID: 123}, Data: statusCancel}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateSelfie}, nil)
+				dsMock.EXPECT().CancelVerification(gomock.Any()).Return(nil)
+				cacheMock.EXPECT().RemoveSession(gomock.Any(), gomock.Eq("123")).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: exitExpert,
+			args: args{&tgbotapi.CallbackQuery{ID: "24", From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{MessageID: 24, Chat: &tgbotapi.Chat{ID: 123}}, Data: exitExpert}},
+			f: func() {
+				tBot.expert.enter(123, time.Now().Add(time.Hour))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
 		{
 			name: "undefined button data",
-			args: args{&tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 123}, Data: "default"}},
-			f:    func() {},
-			err:  errors.New("undefined button data"),
+			args: args{&tgbotapi.CallbackQuery{ID: "9", From: &tgbotapi.User{ID: 123}, Data: "default"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("undefined button data"),
 		},
 	}
 	for _, tt := range tests {
@@ -456,6 +816,7 @@ func Test_telegramBot_commandParse(t *testing.T) {
 		dsClient: dsMock,
 		cache:    cacheMock,
 	}
+	tBot.commands = newCommandRegistry(tBot.defaultCommands()...)
 	type args struct {
 		message *tgbotapi.Message
 	}
@@ -480,7 +841,7 @@ func Test_telegramBot_commandParse(t *testing.T) {
 				dsMock.EXPECT().GetVerificationByShortID(gomock.Eq("test")).Return(&dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{Status: pending}}}, nil)
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
 				dsMock.EXPECT().LinkTelegramProfile(gomock.Eq("test"), gomock.Any()).Return(nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
@@ -494,7 +855,7 @@ func Test_telegramBot_commandParse(t *testing.T) {
 				dsMock.EXPECT().GetVerificationByShortID(gomock.Eq("test")).Return(&dataspike.Verification{}, nil)
 				dsMock.EXPECT().GetApplicantByID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
 				dsMock.EXPECT().LinkTelegramProfile(gomock.Eq("test"), gomock.Any()).Return(nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 			},
 			err: errors.New("set verification error"),
 		},
@@ -550,6 +911,14 @@ func Test_telegramBot_commandParse(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			name: "reset_expert",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 13, Type: "bot_command"}}, Text: "/reset_expert", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
 		{
 			name: "customize_bot",
 			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 14, Type: "bot_command"}}, Text: "/customize_bot", From: &tgbotapi.User{ID: 123}}},
@@ -562,9 +931,9 @@ func Test_telegramBot_commandParse(t *testing.T) {
 			name: "cancel",
 			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/cancel", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
 				dsMock.EXPECT().CancelVerification(gomock.Any()).Return(nil)
-				cacheMock.EXPECT().RemoveVerification(gomock.Any(), gomock.Eq("123")).Return(nil)
+				cacheMock.EXPECT().RemoveSession(gomock.Any(), gomock.Eq("123")).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
 			err: nil,
@@ -573,9 +942,9 @@ func Test_telegramBot_commandParse(t *testing.T) {
 			name: "remove verification error",
 			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/cancel", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
 				dsMock.EXPECT().CancelVerification(gomock.Any()).Return(nil)
-				cacheMock.EXPECT().RemoveVerification(gomock.Any(), gomock.Eq("123")).Return(errors.New("remove verification error"))
+				cacheMock.EXPECT().RemoveSession(gomock.Any(), gomock.Eq("123")).Return(errors.New("remove verification error"))
 			},
 			err: errors.New("remove verification error"),
 		},
@@ -583,7 +952,7 @@ func Test_telegramBot_commandParse(t *testing.T) {
 			name: "cancel verification error",
 			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/cancel", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
 				dsMock.EXPECT().CancelVerification(gomock.Any()).Return(errors.New("cancel verification error"))
 			},
 			err: errors.New("cancel verification error"),
@@ -592,122 +961,272 @@ func Test_telegramBot_commandParse(t *testing.T) {
 			name: "get verification error",
 			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/cancel", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
 			},
 			err: errors.New("get verification error"),
 		},
 		{
-			name: "start_verification",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "status",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/status", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, RefreshedAt: time.Now(), State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
 			err: nil,
 		},
 		{
-			name: "verification verified",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "status stale",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/status", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Status: verified}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{VerificationUrlId: "short"}, State: flow.StatePoiFront}, nil)
+				dsMock.EXPECT().GetVerificationByShortID(gomock.Eq("short")).Return(&dataspike.Verification{Status: "in_progress"}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, "in_progress", s.Verification.Status)
+					assert.False(t, s.RefreshedAt.IsZero())
+					return nil
+				})
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
 			err: nil,
 		},
 		{
-			name: "send init message error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "status refresh error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/status", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				dsMock.EXPECT().GetVerificationByShortID(gomock.Any()).Return(nil, errors.New("get verification error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("send message error"),
+			err: nil,
 		},
 		{
-			name: "get verification error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "status not found",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/status", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
 			err: errors.New("get verification error"),
 		},
 		{
-			name: "send message error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "resume",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
-				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Id: "1ee20e99-35f7-6c75-811b-6df0f88c424d"}, State: flow.StateLiveness}, nil)
+				dsMock.EXPECT().GetVerificationByID(gomock.Eq(uuid.FromStringOrNil("1ee20e99-35f7-6c75-811b-6df0f88c424d"))).Return(&dataspike.Verification{Checks: dataspike.Checks{Liveness: &dataspike.Check{Status: verified}, Poa: &dataspike.Check{Status: pending}}, Settings: &dataspike.Settings{PoaRequired: true}}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoaCategory, s.State)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("send message error"),
+			err: nil,
 		},
 		{
-			name: "bot error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123, IsBot: true}}},
+			name: "resume failed",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("bot error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa}, nil)
+				dsMock.EXPECT().GetVerificationByID(gomock.Any()).Return(&dataspike.Verification{Status: "failed"}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StateDone, s.State)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, VerificationFailed, req.Form.Get("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
 			},
-			err: errors.New("bot error"),
+			err: nil,
 		},
 		{
-			name: "create_verification",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "resume expired",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				tBot.dev = true
-				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
-				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Eq(&dataspike.Verification{})).Return(nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa}, nil)
+				dsMock.EXPECT().GetVerificationByID(gomock.Any()).Return(&dataspike.Verification{Status: "expired"}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StateDone, s.State)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, expiredText, req.Form.Get("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
 			},
 			err: nil,
 		},
 		{
-			name: "send message error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "resume canceled",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				tBot.dev = true
-				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
-				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Eq(&dataspike.Verification{})).Return(nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa}, nil)
+				dsMock.EXPECT().GetVerificationByID(gomock.Any()).Return(&dataspike.Verification{Status: "canceled"}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StateDone, s.State)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, cancelText, req.Form.Get("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
 			},
-			err: errors.New("send message error"),
+			err: nil,
 		},
 		{
-			name: "set verification error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "resume under review",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				tBot.dev = true
-				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
-				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(&dataspike.Verification{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Eq(&dataspike.Verification{})).Return(errors.New("set verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				dsMock.EXPECT().GetVerificationByID(gomock.Any()).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("set verification error"),
+			err: nil,
 		},
 		{
-			name: "create verification error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "resume get verification error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				tBot.dev = true
-				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
-				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(nil, errors.New("create verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				dsMock.EXPECT().GetVerificationByID(gomock.Any()).Return(nil, errors.New("get verification error"))
 			},
-			err: errors.New("create verification error"),
+			err: errors.New("get verification error"),
 		},
 		{
-			name: "link tg error",
-			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			name: "resume set verification error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
 			f: func() {
-				tBot.dev = true
-				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(nil, errors.New("dataspike error: 404"))
-				dsMock.EXPECT().CreateApplicant(gomock.Any()).Return("test", nil)
-				dsMock.EXPECT().LinkTelegramProfile(gomock.Eq("test"), gomock.Eq("123")).Return(errors.New("link tg error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				dsMock.EXPECT().GetVerificationByID(gomock.Any()).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 			},
-			err: errors.New("link tg error"),
+			err: errors.New("set verification error"),
+		},
+		{
+			name: "resume not found",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 7, Type: "bot_command"}}, Text: "/resume", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get session error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("get session error"),
+		},
+		{
+			name: "start_verification",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "verification verified",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Status: verified}, State: flow.StateDone}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "send init message error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
+			},
+			err: errors.New("send message error"),
+		},
+		{
+			name: "get verification error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("get verification error"),
+		},
+		{
+			name: "send message error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
+			},
+			err: errors.New("send message error"),
+		},
+		{
+			name: "bot error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 19, Type: "bot_command"}}, Text: "/start_verification", From: &tgbotapi.User{ID: 123, IsBot: true}}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("bot error"))
+			},
+			err: errors.New("bot error"),
+		},
+		{
+			name: "create_verification",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				tBot.dev = true
+				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
+				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "send message error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				tBot.dev = true
+				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
+				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
+			},
+			err: errors.New("send message error"),
+		},
+		{
+			name: "set verification error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				tBot.dev = true
+				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
+				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(&dataspike.Verification{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+			},
+			err: errors.New("set verification error"),
+		},
+		{
+			name: "create verification error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				tBot.dev = true
+				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(&dataspike.Applicant{ApplicantId: "test"}, nil)
+				dsMock.EXPECT().CreateVerification(gomock.Any()).Return(nil, errors.New("create verification error"))
+			},
+			err: errors.New("create verification error"),
+		},
+		{
+			name: "link tg error",
+			args: args{&tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 20, Type: "bot_command"}}, Text: "/create_verification", From: &tgbotapi.User{ID: 123}}},
+			f: func() {
+				tBot.dev = true
+				dsMock.EXPECT().GetApplicantByExternalID(gomock.Any()).Return(nil, errors.New("dataspike error: 404"))
+				dsMock.EXPECT().CreateApplicant(gomock.Any()).Return("test", nil)
+				dsMock.EXPECT().LinkTelegramProfile(gomock.Eq("test"), gomock.Eq("123")).Return(errors.New("link tg error"))
+			},
+			err: errors.New("link tg error"),
 		},
 		{
 			name: "get applicant error",
@@ -859,8 +1378,8 @@ func Test_telegramBot_nextCheck(t *testing.T) {
 		cache:    cacheMock,
 	}
 	type args struct {
-		chatID       int64
-		verification *dataspike.Verification
+		chatID  int64
+		session *flow.Session
 	}
 	tests := []struct {
 		name string
@@ -870,7 +1389,7 @@ func Test_telegramBot_nextCheck(t *testing.T) {
 	}{
 		{
 			name: "DocumentMrz",
-			args: args{123, &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{Check: dataspike.Check{Status: "pending"}}}}},
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}},
 			f: func() {
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
@@ -879,7 +1398,7 @@ func Test_telegramBot_nextCheck(t *testing.T) {
 		},
 		{
 			name: "DocumentMrz send message error",
-			args: args{123, &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{Check: dataspike.Check{Status: "pending"}}}}},
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}},
 			f: func() {
 				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
 			},
@@ -887,7 +1406,7 @@ func Test_telegramBot_nextCheck(t *testing.T) {
 		},
 		{
 			name: "FaceComparison",
-			args: args{123, &dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{Status: "pending"}}}},
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StateSelfie}},
 			f: func() {
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
@@ -895,7 +1414,7 @@ func Test_telegramBot_nextCheck(t *testing.T) {
 		},
 		{
 			name: "Liveness",
-			args: args{123, &dataspike.Verification{Checks: dataspike.Checks{Liveness: &dataspike.Check{Status: "pending"}}}},
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StateLiveness}},
 			f: func() {
 				tBot.bot.Self = tgbotapi.User{UserName: "test"}
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
@@ -904,15 +1423,30 @@ func Test_telegramBot_nextCheck(t *testing.T) {
 		},
 		{
 			name: "Poa",
-			args: args{123, &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{Status: "pending"}}, Settings: &dataspike.Settings{PoaRequired: true}}},
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{PoaRequired: true}}, State: flow.StatePoa, PoaCategory: "utility_bill"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "Poa undefined category",
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa}},
+			f:    func() {},
+			err:  errors.New(`undefined poa category ""`),
+		},
+		{
+			name: "Poa category",
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{PoaRequired: false}}, State: flow.StatePoaCategory}},
 			f: func() {
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
 			err: nil,
 		},
 		{
 			name: "Poa skip send message error",
-			args: args{123, &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{Status: "pending"}}, Settings: &dataspike.Settings{PoaRequired: false}}},
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{PoaRequired: false}}, State: flow.StatePoaCategory}},
 			f: func() {
 				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
 			},
@@ -920,18 +1454,49 @@ func Test_telegramBot_nextCheck(t *testing.T) {
 		},
 		{
 			name: "Proceed verification error",
-			args: args{123, &dataspike.Verification{}},
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}},
 			f: func() {
 				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(errors.New("proceed verification error"))
 			},
 			err: errors.New("proceed verification error"),
 		},
+		{
+			name: "DocumentMrz chosen document",
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront, DocumentType: "id_card"}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "document type",
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{PoiAllowedDocuments: []string{"passport"}}}, State: flow.StatePoiType}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "issued country",
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{Settings: &dataspike.Settings{Countries: []string{"DE", "FR", "IT", "ES"}}}, State: flow.StatePoiCountry}},
+			f: func() {
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "no prompt",
+			args: args{123, &flow.Session{Verification: &dataspike.Verification{}, State: flow.StateNew}},
+			f:    func() {},
+			err:  errors.New(`state "new" has no prompt`),
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			tt.f()
-			err = tBot.nextCheck(tt.args.chatID, tt.args.verification)
+			err = tBot.nextCheck(tt.args.chatID, tt.args.session)
 			assert.Equal(t, tt.err, err)
 		})
 	}
@@ -951,10 +1516,11 @@ func Test_telegramBot_parseDocument(t *testing.T) {
 	}
 
 	tBot := &TelegramBot{
-		bot:        bot,
-		dsClient:   dsMock,
-		cache:      cacheMock,
-		httpClient: httpMock,
+		bot:             bot,
+		dsClient:        dsMock,
+		cache:           cacheMock,
+		httpClient:      httpMock,
+		downloadBackoff: time.Millisecond,
 	}
 	type args struct {
 		message *tgbotapi.Message
@@ -969,11 +1535,11 @@ func Test_telegramBot_parseDocument(t *testing.T) {
 			name: "DocumentMrz",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
 				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
@@ -984,63 +1550,83 @@ func Test_telegramBot_parseDocument(t *testing.T) {
 			name: "DocumentMrz front",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
 				twoSide := true
 				side := Front
 				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{DetectedTwoSideDocument: &twoSide, DetectedDocumentSide: &side}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
 			err: nil,
 		},
 		{
-			name: "set verification error",
+			name: "DocumentMrz chosen two-sided document",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, State: flow.StatePoiFront, DocumentType: "id_card", IssuedCountry: "DE"}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).DoAndReturn(func(upload *dataspike.DocumentUpload) (*dataspike.Document, error) {
+					assert.Equal(t, "DE", *upload.IssuedCountry)
+					assert.Equal(t, Front, *upload.Side)
+					return &dataspike.Document{DocumentId: "front"}, nil
+				})
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoiBack, s.State)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("set verification error"),
+			err: nil,
 		},
 		{
-			name: "Document error",
+			name: "document not chosen",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiType}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{0, "document error"}}}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("Code: 0; Message: document error"),
+			err: nil,
 		},
 		{
-			name: "upload document error",
+			name: "DocumentMrz poor quality",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(nil, errors.New("upload document error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(thumbnail(t)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Contains(t, req.Form.Get("text"), "The photo is too small.")
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("upload document error"),
+			err: nil,
 		},
 		{
-			name: "FaceComparison",
-			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			name: "DocumentMrz normalized",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "scan.png"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).DoAndReturn(func(upload *dataspike.DocumentUpload) (*dataspike.Document, error) {
+					assert.Equal(t, "scan.jpg", upload.FileName)
+					_, format, err := image.DecodeConfig(upload.Reader)
+					assert.NoError(t, err)
+					assert.Equal(t, "jpeg", format)
+					return &dataspike.Document{}, nil
+				})
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
@@ -1048,51 +1634,142 @@ func Test_telegramBot_parseDocument(t *testing.T) {
 			err: nil,
 		},
 		{
-			name: "set verification error",
-			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			name: "DocumentMrz file type not allowed",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "passport.jpg"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader([]byte("PK\x03\x04archive")))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Contains(t, req.Form.Get("text"), "JPEG, PNG, GIF, WebP, TIFF.")
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("set verification error"),
+			err: nil,
+		},
+		{
+			name: "DocumentMrz image too large",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "passport.png"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(oversizedPhoto(t)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, imageTooLargeText, req.Form.Get("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "DocumentMrz damaged image",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "passport.png"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				// the truncated photo is refused rather than uploaded with its metadata
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)[:200]))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, imageUnreadableText, req.Form.Get("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "DocumentMrz file too large",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test", FileSize: 50 << 20}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, "The file is too large. Please send a file up to 20 MB.", req.Form.Get("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				// the refused document is deleted all the same
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.True(t, strings.HasSuffix(req.URL.Path, "/deleteMessage"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":true}`)))}, nil
+				})
+			},
+			err: nil,
+		},
+		{
+			name: "Selfie duplicates the document",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Photo: []tgbotapi.PhotoSize{{FileID: "123"}}}},
+			f: func() {
+				img, err := imaging.Decode(photo(t))
+				assert.NoError(t, err)
+				uploads := []flow.Upload{{DocumentID: "front", DocType: Poi, State: flow.StatePoiFront, PHash: uint64(imaging.DHash(img))}}
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateSelfie, Uploads: uploads}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, fmt.Sprintf(duplicatePhotoText, "your identity document"), req.Form.Get("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
 		},
 		{
-			name: "Document error",
+			name: "DocumentMrz back instead of front",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				twoSide := true
+				side := Back
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{DetectedTwoSideDocument: &twoSide, DetectedDocumentSide: &side}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{0, "document error"}}}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("Code: 0; Message: document error"),
+			err: nil,
 		},
 		{
-			name: "upload document error",
+			name: "DocumentMrz front instead of back",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiBack}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				twoSide := true
+				side := Front
+				dsMock.EXPECT().UploadDocument(gomock.Any()).DoAndReturn(func(upload *dataspike.DocumentUpload) (*dataspike.Document, error) {
+					assert.Equal(t, Back, *upload.Side)
+					return &dataspike.Document{DocumentId: "front", DetectedTwoSideDocument: &twoSide, DetectedDocumentSide: &side}, nil
+				})
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoiBack, s.State)
+					assert.Empty(t, s.FrontDocumentID)
+					return nil
+				})
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(nil, errors.New("upload document error"))
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("upload document error"),
+			err: nil,
 		},
 		{
-			name: "Poa",
+			name: "DocumentMrz back",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiBack}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				twoSide := true
+				side := Back
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{DocumentId: "back", DetectedTwoSideDocument: &twoSide, DetectedDocumentSide: &side}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
@@ -1103,94 +1780,309 @@ func Test_telegramBot_parseDocument(t *testing.T) {
 			name: "set verification error",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
 				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
-				cacheMock.EXPECT().SetVerification(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
 			err: errors.New("set verification error"),
 		},
 		{
-			name: "Document error",
+			name: "Document rejected",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
 				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{0, "document error"}}}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoiFront, s.State)
+					assert.Equal(t, map[flow.State]int{flow.StatePoiFront: 1}, s.Attempts)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("Code: 0; Message: document error"),
+			err: nil,
 		},
 		{
-			name: "upload document error",
+			name: "Document rejected set verification error",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
-				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(nil, errors.New("upload document error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{Code: 0, Message: "document error"}}}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("upload document error"),
+			err: errors.New("set verification error"),
 		},
 		{
-			name: "empty checks error",
+			name: "Document rejected last attempt",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront, Attempts: map[flow.State]int{flow.StatePoiFront: defaultRetryBudget - 1}}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{Code: 0, Message: "document error"}}}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("status not supported for upload document"),
+			err: nil,
 		},
 		{
-			name: "do request error",
+			name: "Document attempts exhausted",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{}}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront, Attempts: map[flow.State]int{flow.StatePoiFront: defaultRetryBudget}}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("do request error"))
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("do request error"),
+			err: nil,
 		},
 		{
-			name: "url error",
+			name: "upload document error",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{"file_path":")(<>!@#  $%^&*"}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{DocumentMrz: &dataspike.DocumentMrz{}}}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(nil, errors.New("upload document error"))
 				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: &url.Error{Op: "parse", URL: "https://api.telegram.org/file/bot/)(<>!@#  $%^&*", Err: url.EscapeError("%^&")},
+			err: errors.New("upload document error"),
 		},
 		{
-			name: "get file error",
+			name: "FaceComparison",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(&dataspike.Verification{Checks: dataspike.Checks{}}, nil)
-				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("get file error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, State: flow.StateSelfie}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("get file error"),
+			err: nil,
 		},
 		{
-			name: "get verification error",
+			name: "set verification error",
 			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
 			f: func() {
-				cacheMock.EXPECT().GetVerification(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, State: flow.StateSelfie}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
 			},
-			err: errors.New("get verification error"),
+			err: errors.New("set verification error"),
 		},
-	}
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
-			tt.f()
-			err = tBot.ParseDocument(ctx, tt.args.message)
+		{
+			name: "Document rejected",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, State: flow.StateSelfie}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{0, "document error"}}}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "upload document error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{FaceComparison: &dataspike.Check{}}}, State: flow.StateSelfie}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(nil, errors.New("upload document error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("upload document error"),
+		},
+		{
+			name: "Poa",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, State: flow.StatePoa, PoaCategory: "bank_statement"}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).DoAndReturn(func(upload *dataspike.DocumentUpload) (*dataspike.Document, error) {
+					assert.Equal(t, "bank_statement_test.jpg", upload.FileName)
+					return &dataspike.Document{DocumentId: "page"}, nil
+				})
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, flow.StatePoa, s.State)
+					assert.Equal(t, []flow.Upload{{DocumentID: "page", DocType: Poa, State: flow.StatePoa, Category: "bank_statement", SHA256: s.Uploads[0].SHA256, PHash: s.Uploads[0].PHash, At: s.Uploads[0].At}}, s.Uploads)
+					assert.NotZero(t, s.Uploads[0].PHash)
+					return nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "Poa pages limit",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				pages := make([]flow.Upload, maxPoaPages-1)
+				for i := range pages {
+					pages[i] = flow.Upload{DocType: Poa, State: flow.StatePoa}
+				}
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, State: flow.StatePoa, Uploads: pages}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "set verification error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, State: flow.StatePoa}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(errors.New("set verification error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("set verification error"),
+		},
+		{
+			name: "Document rejected",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, State: flow.StatePoa}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{0, "document error"}}}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "upload document error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, State: flow.StatePoa}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(nil, errors.New("upload document error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("upload document error"),
+		},
+		{
+			name: "empty checks error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{}}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("status not supported for upload document"),
+		},
+		{
+			name: "do request error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{}}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("do request error")).Times(defaultDownloadAttempts)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("do request error"),
+		},
+		{
+			name: "download status error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewReader([]byte("<html>Not Found</html>")))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: &downloadStatusError{status: http.StatusNotFound},
+		},
+		{
+			name: "download retried",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusBadGateway, Body: io.NopCloser(bytes.NewReader([]byte("<html>Bad Gateway</html>")))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{}, nil)
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					sum := sha256.Sum256(photo(t))
+					assert.Equal(t, hex.EncodeToString(sum[:]), s.Uploads[0].SHA256)
+					return nil
+				})
+				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: nil,
+		},
+		{
+			name: "url error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{Poa: &dataspike.Check{}}}, State: flow.StatePoa}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{"file_path":")(<>!@#  $%^&*"}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: &url.Error{Op: "parse", URL: "https://api.telegram.org/file/bot/)(<>!@#  $%^&*", Err: url.EscapeError("%^&")},
+		},
+		{
+			name: "get file error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{Checks: dataspike.Checks{}}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("get file error"))
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+			},
+			err: errors.New("get file error"),
+		},
+		{
+			name: "get verification error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Document: &tgbotapi.Document{FileID: "123", FileName: "test"}}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
+				// the document sent without a session is deleted all the same
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.True(t, strings.HasSuffix(req.URL.Path, "/deleteMessage"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":true}`)))}, nil
+				})
+			},
+			err: errors.New("get verification error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f()
+			err = tBot.ParseDocument(ctx, tt.args.message)
 			assert.Equal(t, tt.err, err)
 		})
 	}
@@ -1210,9 +2102,18 @@ func Test_telegramBot_textParse(t *testing.T) {
 	}
 
 	tBot := &TelegramBot{
-		bot:      bot,
-		dsClient: dsMock,
-		cache:    cacheMock,
+		bot:           bot,
+		dsClient:      dsMock,
+		cache:         cacheMock,
+		llm:           &StubLLM{Reply: "KYC is the identity verification."},
+		expertTimeout: time.Hour,
+	}
+	sentText := func(want string) {
+		httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			assert.NoError(t, req.ParseForm())
+			assert.Equal(t, want, req.FormValue("text"))
+			return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+		})
 	}
 	type args struct {
 		message *tgbotapi.Message
@@ -1224,20 +2125,59 @@ func Test_telegramBot_textParse(t *testing.T) {
 		err  error
 	}{
 		{
-			name: "non gpt",
-			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}}},
+			name: "no verification",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "hi"}},
 			f: func() {
-				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("verification not found"))
+				sentText(helpText)
+			},
+			err: nil,
+		},
+		{
+			name: "step help",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "where do I upload?"}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				sentText(fmt.Sprintf(stepHelpText, "uploading the identity document", stepFor(flow.StatePoiFront).help))
+			},
+			err: nil,
+		},
+		{
+			name: "under review",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "done"}},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				sentText(fmt.Sprintf(stepStatusText, "documents are being reviewed"))
 			},
 			err: nil,
 		},
 		{
 			name: "gpt",
-			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}}},
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "What is KYC?"}},
+			f: func() {
+				tBot.expert.enter(123, time.Now().Add(time.Minute))
+				sentText("KYC is the identity verification.")
+			},
+			err: nil,
+		},
+		{
+			name: "gpt error",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "What is KYC?"}},
 			f: func() {
-				tBot.gptClient, _ = chatgpt.NewClient("test")
+				tBot.llm = &StubLLM{Err: errors.New("llm request failed with status 401: Incorrect API key provided")}
 			},
-			err: errors.New("api request failed: status Code: 401 401 Unauthorized https://api.openai.com/v1/chat/completions Message: map[error:map[code:invalid_api_key message:Incorrect API key provided: test. You can find your API key at https://platform.openai.com/account/api-keys. param:<nil> type:invalid_request_error]]"),
+			err: errors.New("llm request failed with status 401: Incorrect API key provided"),
+		},
+		{
+			name: "expert mode timed out",
+			args: args{&tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "What is KYC?"}},
+			f: func() {
+				tBot.expert.enter(123, time.Now().Add(-time.Second))
+				sentText(expertModeTimedOut)
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("verification not found"))
+				sentText(helpText)
+			},
+			err: nil,
 		},
 	}
 	for _, tt := range tests {
@@ -1249,3 +2189,1166 @@ func Test_telegramBot_textParse(t *testing.T) {
 		})
 	}
 }
+
+func Test_expertModes_touch(t *testing.T) {
+	t.Parallel()
+	var m expertModes
+	now := time.Now()
+
+	active, expired := m.touch(1, now, time.Minute)
+	assert.False(t, active)
+	assert.False(t, expired)
+
+	m.enter(1, now.Add(time.Minute))
+	active, _ = m.touch(1, now.Add(50*time.Second), time.Minute)
+	assert.True(t, active)
+	// every question prolongs the mode
+	active, _ = m.touch(1, now.Add(100*time.Second), time.Minute)
+	assert.True(t, active)
+
+	active, expired = m.touch(1, now.Add(3*time.Minute), time.Minute)
+	assert.False(t, active)
+	assert.True(t, expired)
+	_, expired = m.touch(1, now.Add(3*time.Minute), time.Minute)
+	assert.False(t, expired)
+
+	m.enter(2, now.Add(time.Minute))
+	m.leave(2)
+	active, expired = m.touch(2, now, time.Minute)
+	assert.False(t, active)
+	assert.False(t, expired)
+}
+
+func Test_callbackGuard_acquire(t *testing.T) {
+	t.Parallel()
+	var g callbackGuard
+	now := time.Now()
+
+	assert.True(t, g.acquire("123:1", now))
+	assert.False(t, g.acquire("123:1", now.Add(time.Second)))
+	assert.True(t, g.acquire("123:2", now.Add(time.Second)))
+	assert.True(t, g.acquire("123:1", now.Add(callbackTTL+2*time.Second)))
+
+	g.release("123:2")
+	assert.True(t, g.acquire("123:2", now.Add(2*time.Second)))
+}
+
+func Test_commandRegistry_menus(t *testing.T) {
+	t.Parallel()
+	handler := func(context.Context, *tgbotapi.Message) error { return nil }
+	r := newCommandRegistry(
+		Command{Name: "start", Handler: handler, Hidden: true},
+		Command{Name: "help", Description: "Help", Handler: handler},
+		Command{Name: "hilfe", Description: "Hilfe", Handler: handler, Languages: []string{"de"}},
+		Command{Name: "create_verification", Description: "Create", Handler: handler, Visibility: VisibilitySandbox},
+		Command{Name: "stats", Description: "Stats", Handler: handler, Visibility: VisibilityAdmin},
+	)
+	r.register(Command{Name: "help", Description: "Get help", Handler: handler})
+
+	defaultScope := tgbotapi.NewBotCommandScopeDefault()
+	adminScope := tgbotapi.NewBotCommandScopeChat(42)
+	tests := []struct {
+		name   string
+		dev    bool
+		admins []int64
+		want   []tgbotapi.SetMyCommandsConfig
+	}{
+		{
+			name: "public",
+			want: []tgbotapi.SetMyCommandsConfig{
+				{Scope: &defaultScope, Commands: []tgbotapi.BotCommand{{Command: "help", Description: "Get help"}}},
+				{Scope: &defaultScope, LanguageCode: "de", Commands: []tgbotapi.BotCommand{{Command: "help", Description: "Get help"}, {Command: "hilfe", Description: "Hilfe"}}},
+			},
+		},
+		{
+			name:   "sandbox and admin",
+			dev:    true,
+			admins: []int64{42},
+			want: []tgbotapi.SetMyCommandsConfig{
+				{Scope: &defaultScope, Commands: []tgbotapi.BotCommand{{Command: "help", Description: "Get help"}, {Command: "create_verification", Description: "Create"}}},
+				{Scope: &defaultScope, LanguageCode: "de", Commands: []tgbotapi.BotCommand{{Command: "help", Description: "Get help"}, {Command: "hilfe", Description: "Hilfe"}, {Command: "create_verification", Description: "Create"}}},
+				{Scope: &adminScope, Commands: []tgbotapi.BotCommand{{Command: "help", Description: "Get help"}, {Command: "create_verification", Description: "Create"}, {Command: "stats", Description: "Stats"}}},
+				{Scope: &adminScope, LanguageCode: "de", Commands: []tgbotapi.BotCommand{{Command: "help", Description: "Get help"}, {Command: "hilfe", Description: "Hilfe"}, {Command: "create_verification", Description: "Create"}, {Command: "stats", Description: "Stats"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, r.menus(tt.dev, tt.admins))
+		})
+	}
+}
+
+func Test_commandRegistry_register(t *testing.T) {
+	t.Parallel()
+	handler := func(context.Context, *tgbotapi.Message) error { return nil }
+	r := newCommandRegistry(
+		Command{Name: "help", Description: "Help", Handler: handler},
+		Command{Name: "Help-Me", Description: "Help", Handler: handler},
+		Command{Name: "stats", Description: "Stats"},
+		Command{Name: "start", Handler: handler, Hidden: true},
+		Command{Name: "status", Handler: handler},
+	)
+
+	_, ok := r.lookup("stats")
+	assert.False(t, ok)
+	_, ok = r.lookup("start")
+	assert.True(t, ok)
+	assert.EqualError(t, r.err, `command "Help-Me": the name must be 1-32 lowercase letters, digits or underscores
+command "stats" has no handler
+command "status" has no description for the menu`)
+}
+
+func Test_telegramBot_syncCommands(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+
+	store := NewMenuFileStore(filepath.Join(t.TempDir(), "menus.json"))
+	assert.NoError(t, store.SaveMenus([]MenuScope{{}, {Language: "de"}, {ChatID: 42}}))
+	tBot := &TelegramBot{bot: bot, menus: store}
+	tBot.commands = newCommandRegistry(tBot.defaultCommands()...)
+
+	var requests []string
+	httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		assert.NoError(t, req.ParseForm())
+		requests = append(requests, path.Base(req.URL.Path)+" "+req.FormValue("scope")+" "+req.FormValue("language_code"))
+		return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":true}`)))}, nil
+	}).Times(3)
+
+	assert.NoError(t, tBot.syncCommands())
+	assert.Equal(t, []string{
+		`setMyCommands {"type":"default"} `,
+		`deleteMyCommands {"type":"default"} de`,
+		`deleteMyCommands {"type":"chat","chat_id":42} `,
+	}, requests)
+
+	scopes, err := store.LoadMenus()
+	assert.NoError(t, err)
+	assert.Equal(t, []MenuScope{{}}, scopes)
+}
+
+func Test_telegramBot_ParseAlbum(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+	dsMock := mock_telegram_bot.NewMockIDataspikeClient(ctrl)
+	cacheMock := mock_telegram_bot.NewMockICache(ctrl)
+	ctx := context.Background()
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+
+	tBot := &TelegramBot{
+		bot:        bot,
+		dsClient:   dsMock,
+		cache:      cacheMock,
+		httpClient: httpMock,
+	}
+	album := []*tgbotapi.Message{
+		{MessageID: 1, From: &tgbotapi.User{ID: 123}, MediaGroupID: "1", Photo: []tgbotapi.PhotoSize{{FileID: "1", FileSize: 1}}},
+		{MessageID: 2, From: &tgbotapi.User{ID: 123}, MediaGroupID: "1", Photo: []tgbotapi.PhotoSize{{FileID: "2", FileSize: 1}}},
+	}
+	front, back := Front, Back
+	tests := []struct {
+		name     string
+		messages []*tgbotapi.Message
+		f        func()
+		err      error
+	}{
+		{
+			name:     "both sides, the back side first",
+			messages: album,
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).DoAndReturn(func(upload *dataspike.DocumentUpload) (*dataspike.Document, error) {
+					// the side is left for dataspike to detect
+					assert.Nil(t, upload.Side)
+					return &dataspike.Document{DocumentId: "back", DetectedDocumentSide: &back}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photoOf(t, 2)))}, nil)
+				dsMock.EXPECT().UploadDocument(gomock.Any()).DoAndReturn(func(upload *dataspike.DocumentUpload) (*dataspike.Document, error) {
+					assert.Equal(t, Front, *upload.Side)
+					return &dataspike.Document{DocumentId: "front", DetectedDocumentSide: &front}, nil
+				})
+				cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, s *flow.Session) error {
+					assert.Equal(t, "front", s.FrontDocumentID)
+					return nil
+				}).Times(2)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				dsMock.EXPECT().ProceedVerification(gomock.Any()).Return(nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil).Times(3)
+			},
+			err: nil,
+		},
+		{
+			name:     "same photo twice",
+			messages: album,
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+				for i := 0; i < 2; i++ {
+					httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+					httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo(t)))}, nil)
+				}
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, fmt.Sprintf(duplicatePhotoText, "your identity document"), req.FormValue("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil).Times(2)
+			},
+			err: nil,
+		},
+		{
+			name:     "proof of address page limit",
+			messages: album,
+			f: func() {
+				uploads := make([]flow.Upload, maxPoaPages-1)
+				for i := range uploads {
+					uploads[i] = flow.Upload{DocType: Poa, State: flow.StatePoa}
+				}
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoa, PoaCategory: "utility_bill", Uploads: uploads}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Equal(t, fmt.Sprintf(albumTooLarge, 1), req.FormValue("text"))
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil).Times(2)
+			},
+			err: nil,
+		},
+		{
+			name:     "too many photos",
+			messages: album,
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateSelfie}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil).Times(3)
+			},
+			err: nil,
+		},
+		{
+			name:     "not expected",
+			messages: album,
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil).Times(2)
+			},
+			err: errors.New("status not supported for upload document"),
+		},
+		{
+			name:     "get verification error",
+			messages: album,
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(nil, errors.New("get verification error"))
+				for range album {
+					httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+				}
+			},
+			err: errors.New("get verification error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f()
+			err = tBot.ParseAlbum(ctx, tt.messages)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func Test_telegramBot_albumPartlyUploaded(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+	dsMock := mock_telegram_bot.NewMockIDataspikeClient(ctrl)
+	cacheMock := mock_telegram_bot.NewMockICache(ctrl)
+	ctx := context.Background()
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+
+	tBot := &TelegramBot{bot: bot, dsClient: dsMock, cache: cacheMock, httpClient: httpMock}
+	album := []*tgbotapi.Message{
+		{MessageID: 1, From: &tgbotapi.User{ID: 123}, MediaGroupID: "1", Photo: []tgbotapi.PhotoSize{{FileID: "1", FileSize: 1}}},
+		{MessageID: 2, From: &tgbotapi.User{ID: 123}, MediaGroupID: "1", Photo: []tgbotapi.PhotoSize{{FileID: "2", FileSize: 1}}},
+	}
+
+	var sent []string
+	var seed int64
+	httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		if strings.Contains(req.URL.Path, "/file/") {
+			seed++
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photoOf(t, seed)))}, nil
+		}
+		assert.NoError(t, req.ParseForm())
+		if text := req.FormValue("text"); text != "" {
+			sent = append(sent, text)
+		}
+		return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{"file_path":"photo.jpg"}}`)))}, nil
+	}).AnyTimes()
+
+	session := &flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront, DocumentType: "id_card"}
+	cacheMock.EXPECT().GetSession(gomock.Any(), gomock.Eq("123")).Return(session, nil)
+	dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{DocumentId: "front"}, nil)
+	dsMock.EXPECT().UploadDocument(gomock.Any()).Return(&dataspike.Document{Errors: dataspike.Errors{{Code: 0, Message: "glare"}}}, nil)
+	cacheMock.EXPECT().SetSession(gomock.Any(), gomock.Eq("123"), gomock.Any()).Return(nil).Times(2)
+
+	assert.NoError(t, tBot.ParseAlbum(ctx, album))
+	assert.Equal(t, flow.StatePoiBack, session.State)
+	assert.Equal(t, "front", session.FrontDocumentID)
+	assert.Len(t, session.Uploads, 1)
+	assert.Equal(t, 1, session.Attempts[flow.StatePoiBack])
+	assert.Equal(t, fmt.Sprintf(albumPartlyReceived, 1, 2), sent[0])
+	assert.Equal(t, fmt.Sprintf(uploadRejectedText, "- glare", defaultRetryBudget-1), sent[len(sent)-1])
+}
+
+func Test_pairSides(t *testing.T) {
+	t.Parallel()
+	front, back := Front, Back
+	tests := []struct {
+		name      string
+		a, b      *dataspike.Document
+		wantFront string
+		ok        bool
+	}{
+		{name: "ordered", a: &dataspike.Document{DocumentId: "a", DetectedDocumentSide: &front}, b: &dataspike.Document{DocumentId: "b", DetectedDocumentSide: &back}, wantFront: "a", ok: true},
+		{name: "reversed", a: &dataspike.Document{DocumentId: "a", DetectedDocumentSide: &back}, b: &dataspike.Document{DocumentId: "b"}, wantFront: "b", ok: true},
+		{name: "unknown sides", a: &dataspike.Document{DocumentId: "a"}, b: &dataspike.Document{DocumentId: "b"}, wantFront: "a", ok: true},
+		{name: "same side", a: &dataspike.Document{DocumentId: "a", DetectedDocumentSide: &front}, b: &dataspike.Document{DocumentId: "b", DetectedDocumentSide: &front}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			f, _, ok := pairSides(tt.a, tt.b)
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.Equal(t, tt.wantFront, f.DocumentId)
+			}
+		})
+	}
+}
+
+func Test_albumSide(t *testing.T) {
+	t.Parallel()
+	front, back := Front, Back
+	assert.Empty(t, albumSide(flow.StatePoiFront, nil))
+	assert.Equal(t, Back, albumSide(flow.StatePoiFront, []*dataspike.Document{{DetectedDocumentSide: &front}}))
+	assert.Equal(t, Front, albumSide(flow.StatePoiFront, []*dataspike.Document{{DetectedDocumentSide: &back}}))
+	assert.Empty(t, albumSide(flow.StatePoiFront, []*dataspike.Document{{}}))
+	assert.Empty(t, albumSide(flow.StatePoa, []*dataspike.Document{{DetectedDocumentSide: &front}}))
+}
+
+func Test_albumCollector(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{})
+	defer close(done)
+	c := newAlbumCollector(10*time.Millisecond, done)
+
+	c.add(&tgbotapi.Message{MessageID: 2, MediaGroupID: "1"})
+	c.add(&tgbotapi.Message{MessageID: 1, MediaGroupID: "1"})
+	c.add(&tgbotapi.Message{MessageID: 3, MediaGroupID: "2"})
+
+	albums := map[string][]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case messages := <-c.ready:
+			for _, m := range messages {
+				albums[m.MediaGroupID] = append(albums[m.MediaGroupID], m.MessageID)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("album wasn't flushed")
+		}
+	}
+	assert.Equal(t, map[string][]int{"1": {1, 2}, "2": {3}}, albums)
+
+	// the timer reset after it had fired finds the album handed over
+	stale := &album{}
+	c.flush("1", stale)
+	c.add(&tgbotapi.Message{MessageID: 4, MediaGroupID: "1"})
+	c.flush("1", stale)
+	select {
+	case messages := <-c.ready:
+		assert.Equal(t, 4, messages[0].MessageID)
+	case <-time.After(time.Second):
+		t.Fatal("album wasn't flushed")
+	}
+}
+
+func Test_albumCollector_race(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{})
+	defer close(done)
+	// the timers fire while the messages of the album are still being added
+	c := newAlbumCollector(time.Microsecond, done)
+
+	const total = 2000
+	go func() {
+		for i := 1; i <= total; i++ {
+			c.add(&tgbotapi.Message{MessageID: i, MediaGroupID: "1"})
+		}
+	}()
+
+	received := map[int]int{}
+	for len(received) < total {
+		select {
+		case messages := <-c.ready:
+			for _, m := range messages {
+				received[m.MessageID]++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d messages flushed", len(received), total)
+		}
+	}
+	for id, n := range received {
+		assert.Equal(t, 1, n, "message %d", id)
+	}
+}
+
+func Test_explainRejection(t *testing.T) {
+	t.Parallel()
+	errs := dataspike.Errors{{Code: 1, Message: "Document is <blurry>"}, {Code: 2}}
+	assert.Equal(t, "- Document is &lt;blurry&gt;\n- error code 2", explainRejection(errs))
+}
+
+func Test_telegramBot_retryBudget(t *testing.T) {
+	t.Parallel()
+	tBot := &TelegramBot{}
+	WithRetryBudget(flow.StatePoa, 5)(tBot)
+	assert.Equal(t, 5, tBot.retryBudget(flow.StatePoa))
+	assert.Equal(t, defaultRetryBudget, tBot.retryBudget(flow.StateSelfie))
+}
+
+func Test_chatQueues(t *testing.T) {
+	t.Parallel()
+	var q chatQueues
+
+	// another user isn't held up by the slow work of the first one
+	slow := make(chan struct{})
+	q.do(1, func() { <-slow })
+	var order []int
+	q.do(1, func() { order = append(order, 1) })
+	assert.NoError(t, q.wait(2, func() error { return nil }))
+
+	// the work of a user runs in order
+	close(slow)
+	assert.NoError(t, q.wait(1, func() error {
+		order = append(order, 2)
+		return nil
+	}))
+	assert.Equal(t, []int{1, 2}, order)
+	assert.EqualError(t, q.wait(1, func() error { return errors.New("webhook failed") }), "webhook failed")
+}
+
+func Test_telegramBot_livenessURL(t *testing.T) {
+	t.Parallel()
+	tBot := &TelegramBot{bot: &tgbotapi.BotAPI{Self: tgbotapi.User{UserName: "bot"}}}
+	session := &flow.Session{Verification: &dataspike.Verification{VerificationUrl: "https://example.com/v"}}
+	assert.Equal(t, "https://example.com/v?source=telegram&botName=bot", tBot.livenessURL(session))
+	session.Attempts = map[flow.State]int{flow.StateLiveness: 1}
+	assert.Equal(t, "https://example.com/v?source=telegram&botName=bot", tBot.livenessURL(session))
+}
+
+func Test_statusReport(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	session := &flow.Session{
+		Verification: &dataspike.Verification{
+			Status: "in_progress",
+			Checks: dataspike.Checks{
+				DocumentMrz: &dataspike.DocumentMrz{Check: dataspike.Check{Status: "verified"}},
+				Liveness:    &dataspike.Check{Status: "failed", Errors: dataspike.Errors{{Message: "face <not> found"}}},
+				Poa:         &dataspike.Check{Status: pending},
+			},
+			ExpiresAt: now.Add(26*time.Hour + 30*time.Minute),
+		},
+		State: flow.StateLiveness,
+	}
+
+	want := "<b>Verification status:</b> in progress\n" +
+		"<b>Current step:</b> liveness check\n" +
+		"\n" +
+		"Identity document: verified\n" +
+		"Liveness: failed\n" +
+		"  - face &lt;not&gt; found\n" +
+		"Proof of address: pending\n" +
+		"\n" +
+		"<b>Time left:</b> 1d 2h"
+	assert.Equal(t, want, statusReport(session, now))
+
+	session.Verification.ExpiresAt = now.Add(-time.Minute)
+	assert.Contains(t, statusReport(session, now), "The verification has expired.")
+}
+
+func Test_formatRemaining(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 50 * time.Hour, want: "2d 2h"},
+		{d: 3*time.Hour + 5*time.Minute + 10*time.Second, want: "3h 5m"},
+		{d: 25 * time.Minute, want: "25m"},
+		{d: 30 * time.Second, want: "less than a minute"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, formatRemaining(tt.d))
+	}
+}
+
+func Test_telegramBot_scheduleReminders(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := reminder.NewMemoryStore()
+	tBot := &TelegramBot{}
+	WithReminders(store, 30*time.Minute, time.Hour)(tBot)
+
+	session := &flow.Session{Verification: &dataspike.Verification{ExpiresAt: now.Add(3 * time.Hour)}, State: flow.StateSelfie}
+	tBot.scheduleReminders(ctx, "1", session, now)
+	jobs, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []reminder.Job{
+		{TgID: "1", Kind: reminder.KindIdle, At: now.Add(30 * time.Minute)},
+		{TgID: "1", Kind: reminder.KindExpiry, At: now.Add(2 * time.Hour)},
+	}, jobs)
+
+	// the expiry reminder is too late
+	session.Verification.ExpiresAt = now.Add(time.Hour)
+	tBot.scheduleReminders(ctx, "2", session, now)
+	jobs, _ = store.List(ctx)
+	assert.Len(t, jobs, 3)
+
+	session.State = flow.StateReview
+	tBot.scheduleReminders(ctx, "1", session, now)
+	jobs, _ = store.List(ctx)
+	assert.Equal(t, []reminder.Job{{TgID: "2", Kind: reminder.KindIdle, At: now.Add(30 * time.Minute)}}, jobs)
+}
+
+func Test_telegramBot_userActive(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	cacheMock := mock_telegram_bot.NewMockICache(ctrl)
+	store := reminder.NewMemoryStore()
+	tBot := &TelegramBot{cache: cacheMock}
+	WithReminders(store, 30*time.Minute, 0)(tBot)
+
+	// a rejected upload keeps the user in the step, the idle reminder is pushed back all the same
+	cacheMock.EXPECT().GetSession(gomock.Any(), "1").Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StatePoiFront}, nil)
+	before := time.Now()
+	tBot.userActive(ctx, &tgbotapi.User{ID: 1})
+	jobs, err := store.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, reminder.KindIdle, jobs[0].Kind)
+		assert.False(t, jobs[0].At.Before(before.Add(29*time.Minute)))
+		// the updates of the same minute keep the due time
+		assert.Equal(t, jobs[0].At.Truncate(time.Minute), jobs[0].At)
+	}
+
+	// nothing to remind about
+	cacheMock.EXPECT().GetSession(gomock.Any(), "2").Return(nil, errors.New("session not found"))
+	tBot.userActive(ctx, &tgbotapi.User{ID: 2})
+	cacheMock.EXPECT().GetSession(gomock.Any(), "3").Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+	tBot.userActive(ctx, &tgbotapi.User{ID: 3})
+	tBot.userActive(ctx, nil)
+	jobs, _ = store.List(ctx)
+	assert.Len(t, jobs, 1)
+}
+
+func Test_telegramBot_remind(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+	cacheMock := mock_telegram_bot.NewMockICache(ctrl)
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+
+	tBot := &TelegramBot{bot: bot, cache: cacheMock}
+	session := &flow.Session{Verification: &dataspike.Verification{ExpiresAt: time.Now().Add(time.Hour)}, State: flow.StatePoa}
+
+	tests := []struct {
+		name string
+		job  reminder.Job
+		f    func()
+		err  error
+	}{
+		{
+			name: "idle",
+			job:  reminder.Job{TgID: "123", Kind: reminder.KindIdle},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), "123").Return(session, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Contains(t, req.Form.Get("text"), "uploading the proof of address")
+					assert.Contains(t, req.Form.Get("reply_markup"), statusResume)
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+			},
+		},
+		{
+			name: "expiry",
+			job:  reminder.Job{TgID: "123", Kind: reminder.KindExpiry},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), "123").Return(session, nil)
+				httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+					assert.NoError(t, req.ParseForm())
+					assert.Contains(t, req.Form.Get("text"), "Your verification expires in")
+					return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+				})
+			},
+		},
+		{
+			name: "session removed",
+			job:  reminder.Job{TgID: "123", Kind: reminder.KindIdle},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), "123").Return(nil, errors.New("session not found"))
+			},
+		},
+		{
+			name: "under review",
+			job:  reminder.Job{TgID: "123", Kind: reminder.KindIdle},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), "123").Return(&flow.Session{Verification: &dataspike.Verification{}, State: flow.StateReview}, nil)
+			},
+		},
+		{
+			name: "send message error",
+			job:  reminder.Job{TgID: "123", Kind: reminder.KindIdle},
+			f: func() {
+				cacheMock.EXPECT().GetSession(gomock.Any(), "123").Return(session, nil)
+				httpMock.EXPECT().Do(gomock.Any()).Return(nil, errors.New("send message error"))
+			},
+			err: errors.New("send message error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f()
+			assert.Equal(t, tt.err, tBot.remind(ctx, tt.job))
+		})
+	}
+	assert.Equal(t, "1", droppedReminders.Get(string(reminder.KindIdle)).String())
+}
+
+func Test_sanitizeFilename(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		filename string
+		ext      string
+		want     string
+	}{
+		{filename: "passport.jpg", ext: ".jpg", want: "passport.jpg"},
+		{filename: "../../etc/passwd", ext: ".pdf", want: "passwd.pdf"},
+		{filename: `C:\Users\me\bill (1).exe`, ext: ".pdf", want: "bill_1.pdf"},
+		{filename: "счёт.png", ext: ".png", want: "document.png"},
+		{filename: strings.Repeat("a", 100) + ".jpg", ext: ".jpg", want: strings.Repeat("a", 60) + ".jpg"},
+		{filename: "", ext: ".jpg", want: "document.jpg"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sanitizeFilename(tt.filename, tt.ext), tt.filename)
+	}
+}
+
+func Test_telegramBot_checkFileType(t *testing.T) {
+	t.Parallel()
+	tBot := &TelegramBot{}
+
+	_, err := tBot.checkFileType(flow.StatePoiFront, "scan.pdf", []byte("%PDF-1.4"))
+	assert.Equal(t, &refusedFileError{reason: fmt.Sprintf(fileTypeNotAllowedText, "JPEG, PNG, GIF, WebP, TIFF")}, err)
+
+	filename, err := tBot.checkFileType(flow.StatePoa, "scan.jpg", []byte("%PDF-1.4"))
+	assert.NoError(t, err)
+	assert.Equal(t, "scan.pdf", filename)
+
+	filename, err = tBot.checkFileType(flow.StatePoiFront, "scan", []byte("MM\x00*tiff"))
+	assert.NoError(t, err)
+	assert.Equal(t, "scan.tiff", filename)
+
+	WithFileTypes(flow.StatePoa, mimePDF)(tBot)
+	_, err = tBot.checkFileType(flow.StatePoa, "scan", []byte("MM\x00*tiff"))
+	assert.Equal(t, &refusedFileError{reason: fmt.Sprintf(fileTypeNotAllowedText, "PDF")}, err)
+}
+
+func Test_duplicateOf(t *testing.T) {
+	t.Parallel()
+	session := &flow.Session{
+		State: flow.StatePoiBack,
+		Uploads: []flow.Upload{
+			{State: flow.StatePoiFront, PHash: 0xF0F0},
+			{State: flow.StatePoiBack, PHash: 0xFFFF},
+			{State: flow.StatePoa},
+		},
+	}
+
+	of, ok := duplicateOf(session, 0xF0F1)
+	assert.True(t, ok)
+	assert.Equal(t, flow.StatePoiFront, of)
+
+	// photos of the current step and files without a hash are skipped
+	_, ok = duplicateOf(session, 0xFFFF_FFFF_FFFF_FFFF)
+	assert.False(t, ok)
+}
+
+func Test_telegramBot_send_retention(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+
+	store := retention.NewMemoryStore()
+	tBot := &TelegramBot{bot: bot}
+	WithMessageRetention(store, time.Hour)(tBot)
+
+	httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{"message_id":7,"date":1704110400,"chat":{"id":123}}}`)))}, nil)
+	_, err = tBot.send(tgbotapi.NewMessage(123, "text"))
+	assert.NoError(t, err)
+
+	tBot.retainIncoming(&tgbotapi.Message{MessageID: 8, Date: 1704110460, Chat: &tgbotapi.Chat{ID: 123}, Text: "/status"})
+	tBot.retainIncoming(&tgbotapi.Message{MessageID: 9, Date: 1704110460, Chat: &tgbotapi.Chat{ID: 123}, Photo: []tgbotapi.PhotoSize{{FileID: "1"}}})
+
+	messages, err := store.Due(ctx, time.Unix(1704110460, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, []retention.Message{
+		{ChatID: 123, MessageID: 7, SentAt: time.Unix(1704110400, 0)},
+		{ChatID: 123, MessageID: 8, SentAt: time.Unix(1704110460, 0)},
+	}, messages)
+
+	httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		assert.True(t, strings.HasSuffix(req.URL.Path, "/deleteMessage"))
+		return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":true}`)))}, nil
+	})
+	assert.NoError(t, tBot.deleteMessage(ctx, messages[0]))
+
+	// a photo left in the chat is logged
+	var logs bytes.Buffer
+	tBot.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: message to delete not found"}`)))}, nil)
+	tBot.deleteIncoming(&tgbotapi.Message{MessageID: 9, Date: 1704110460, From: &tgbotapi.User{ID: 123}, Photo: []tgbotapi.PhotoSize{{FileID: "1"}}})
+	assert.Contains(t, logs.String(), "message not deleted")
+	assert.Contains(t, logs.String(), "message to delete not found")
+}
+
+func Test_telegramBot_redaction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+	bot.Token = "123456:bot-token"
+
+	var logs bytes.Buffer
+	httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+	iBot, err := NewTelegramBot(bot, nil, nil,
+		WithHTTPClient(httpMock),
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))),
+		WithSecrets("ds-token"),
+		WithDownloadRetries(1, time.Second),
+	)
+	assert.NoError(t, err)
+	tBot := iBot.(*TelegramBot)
+
+	link := "https://api.telegram.org/file/bot" + bot.Token + "/photos/file_1.jpg"
+	httpMock.EXPECT().Do(gomock.Any()).Return(nil, &url.Error{Op: "Get", URL: link, Err: errors.New("connection reset")})
+	_, err = tBot.download(ctx, link)
+	assert.EqualError(t, err, `Get "https://api.telegram.org/file/bot********/photos/file_1.jpg": connection reset`)
+
+	tBot.updateFailed("document", 123, fmt.Errorf("upload with ds-token failed: %w", &url.Error{Op: "Get", URL: link, Err: errors.New("timeout")}))
+	assert.Contains(t, logs.String(), "update handling failed")
+	assert.NotContains(t, logs.String(), bot.Token)
+	assert.NotContains(t, logs.String(), "ds-token")
+}
+
+func Test_telegramBot_expertMemory(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+	ctx := context.Background()
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+	store, err := conversation.NewMemoryStore(0)
+	assert.NoError(t, err)
+
+	llm := &StubLLM{}
+	tBot := &TelegramBot{bot: bot, llm: llm, prompt: "prompt", expertTimeout: time.Hour}
+	tBot.commands = newCommandRegistry(tBot.defaultCommands()...)
+	WithExpertMemory(store)(tBot)
+	sent := func() {
+		httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+	}
+	ask := func(userID int64, text string) {
+		sent()
+		assert.NoError(t, tBot.ParseText(ctx, &tgbotapi.Message{From: &tgbotapi.User{ID: userID}, Text: text}))
+	}
+
+	for _, userID := range []int64{123, 456} {
+		sent()
+		assert.NoError(t, tBot.ParseCommand(ctx, &tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 11, Type: "bot_command"}}, Text: "/ask_expert", From: &tgbotapi.User{ID: userID}}))
+	}
+	ask(123, "Which documents are accepted in France?")
+	ask(456, "What is AML?")
+	ask(123, "And what about for Germany?")
+	requests := llm.Requests()
+	assert.Equal(t, []ChatMessage{
+		{Role: RoleSystem, Content: "prompt"},
+		{Role: RoleUser, Content: "Which documents are accepted in France?"},
+		{Role: RoleAssistant, Content: "Which documents are accepted in France?"},
+		{Role: RoleUser, Content: "And what about for Germany?"},
+	}, requests[len(requests)-1])
+
+	sent()
+	assert.NoError(t, tBot.ParseCommand(ctx, &tgbotapi.Message{Entities: []tgbotapi.MessageEntity{{Length: 13, Type: "bot_command"}}, Text: "/reset_expert", From: &tgbotapi.User{ID: 123}}))
+	ask(123, "Hi")
+	requests = llm.Requests()
+	assert.Equal(t, []ChatMessage{{Role: RoleSystem, Content: "prompt"}, {Role: RoleUser, Content: "Hi"}}, requests[len(requests)-1])
+}
+
+func Test_telegramBot_knowledgeBase(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+	ctx := context.Background()
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+	store, err := conversation.NewMemoryStore(0)
+	assert.NoError(t, err)
+
+	germany := knowledge.Passage{ID: "poi.md#2", Source: "poi.md", Title: "Germany", Text: "German national ID cards are accepted, both sides must be uploaded."}
+	poa := knowledge.Passage{ID: "poa.md#1", Source: "poa.md", Title: "Proof of address", Text: "Utility bills not older than 3 months are accepted."}
+	llm := &StubLLM{Reply: "Yes, German ID cards are accepted [1]."}
+	tBot := &TelegramBot{bot: bot, llm: llm, prompt: "prompt", expertTimeout: time.Hour}
+	WithKnowledgeBase(knowledge.Build([]knowledge.Passage{germany, poa}), 0)(tBot)
+	WithExpertMemory(store)(tBot)
+	tBot.expert.enter(123, time.Now().Add(time.Hour))
+
+	var sent []string
+	httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		assert.NoError(t, req.ParseForm())
+		sent = append(sent, req.FormValue("text"))
+		return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+	}).Times(2)
+
+	assert.NoError(t, tBot.ParseText(ctx, &tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "Is my Germany ID card fine?"}))
+	assert.Equal(t, "Yes, German ID cards are accepted [1].\n\nSources:\n[1] Germany, poi.md", sent[0])
+	assert.Equal(t, []ChatMessage{
+		{Role: RoleSystem, Content: "prompt"},
+		{Role: RoleSystem, Content: knowledgeInstruction + "\n\n[1] Germany (poi.md)\n" + germany.Text},
+		{Role: RoleUser, Content: "Is my Germany ID card fine?"},
+	}, llm.Requests()[0])
+
+	// the follow-up question is searched along with the previous one
+	llm.Reply = "Only the utility bills are accepted."
+	assert.NoError(t, tBot.ParseText(ctx, &tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: "And the proof of address?"}))
+	assert.Equal(t, "Only the utility bills are accepted.", sent[1])
+	assert.Equal(t, ChatMessage{Role: RoleSystem, Content: knowledgeInstruction + "\n\n[1] Proof of address (poa.md)\n" + poa.Text + "\n\n[2] Germany (poi.md)\n" + germany.Text}, llm.Requests()[1][1])
+}
+
+func Test_telegramBot_redactPII(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+	ctx := context.Background()
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+	store, err := conversation.NewMemoryStore(0)
+	assert.NoError(t, err)
+
+	llm := &StubLLM{Reply: "It is fine."}
+	tBot := &TelegramBot{bot: bot, llm: llm, prompt: "prompt", expertTimeout: time.Hour}
+	WithExpertMemory(store)(tBot)
+	tBot.expert.enter(123, time.Now().Add(time.Hour))
+	httpMock.EXPECT().Do(gomock.Any()).Return(&http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil)
+
+	count := func(kind redact.Kind) int64 {
+		if v, ok := llmRedactions.Get(string(kind)).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	emails, phones := count(redact.KindEmail), count(redact.KindPhone)
+
+	text := "Is anna@example.com enough, or should I add +49 30 1234567?"
+	assert.NoError(t, tBot.ParseText(ctx, &tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: text}))
+	assert.Equal(t, ChatMessage{Role: RoleUser, Content: "Is [EMAIL] enough, or should I add [PHONE]?"}, llm.Requests()[0][1])
+	assert.Equal(t, emails+1, count(redact.KindEmail))
+	assert.Equal(t, phones+1, count(redact.KindPhone))
+
+	history, err := tBot.expertMemory.History(ctx, "123")
+	assert.NoError(t, err)
+	assert.Equal(t, "Is [EMAIL] enough, or should I add [PHONE]?", history[0].Content)
+}
+
+func Test_telegramBot_expertOutput(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+	ctx := context.Background()
+
+	bot, err := newBot(httpMock)
+	if err != nil {
+		t.Errorf("error creating bot: %s", err)
+	}
+
+	tBot := &TelegramBot{bot: bot, llm: &StubLLM{}, prompt: "prompt", expertTimeout: time.Hour}
+	WithOutputPolicy(OutputPolicy{BlockedTopics: []string{"weapons", "money laundering"}, AllowedDomains: []string{"dataspike.io"}})(tBot)
+	tBot.expert.enter(123, time.Now().Add(time.Hour))
+
+	paragraph := strings.TrimSpace(strings.Repeat("Документ ", 300))
+	tests := []struct {
+		name  string
+		reply string
+		want  []string
+	}{
+		{
+			name:  "empty",
+			reply: " \n",
+			want:  []string{expertNoAnswer},
+		},
+		{
+			name:  "blocked topic",
+			reply: "Here is how Money Laundering works.",
+			want:  []string{expertAnswerBlocked},
+		},
+		{
+			name:  "links",
+			reply: "See [the docs](https://docs.dataspike.io/kyc), https://evil.example.com/x?y=1 or bit.ly/abc.",
+			want:  []string{"See the docs (https://docs.dataspike.io/kyc), [link removed] or [link removed]."},
+		},
+		{
+			name:  "markdown and html",
+			reply: "## Documents\n\n- **Passport** <script>\n- ID card & `MRZ`",
+			want:  []string{"<b>Documents</b>\n\n• <b>Passport</b> &lt;script&gt;\n• ID card &amp; <code>MRZ</code>"},
+		},
+		{
+			name:  "markdown in code",
+			reply: "## Example\n\n```go\n# **not** a heading\n- x := a**b\n```\n\nUse `**kwargs` **now**",
+			want:  []string{"<b>Example</b>\n\n<pre># **not** a heading\n- x := a**b\n</pre>\n\nUse <code>**kwargs</code> <b>now</b>"},
+		},
+		{
+			name:  "long code block",
+			reply: "```\n" + paragraph + "\n" + paragraph + "\n```",
+			want:  []string{"<pre>" + paragraph + "\n</pre>", "<pre>" + paragraph + "\n</pre>"},
+		},
+		{
+			name:  "long",
+			reply: paragraph + "\n\n" + paragraph + "\n\nDone.",
+			want:  []string{paragraph, paragraph + "\n\nDone."},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []string
+			httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+				assert.NoError(t, req.ParseForm())
+				assert.Equal(t, tgbotapi.ModeHTML, req.FormValue("parse_mode"))
+				sent = append(sent, req.FormValue("text"))
+				return &http.Response{Body: io.NopCloser(bytes.NewReader([]byte(`{"ok":true,"result":{}}`)))}, nil
+			}).Times(len(tt.want))
+
+			// the stub answers with the question
+			assert.NoError(t, tBot.ParseText(ctx, &tgbotapi.Message{From: &tgbotapi.User{ID: 123}, Text: tt.reply}))
+			assert.Equal(t, tt.want, sent)
+		})
+	}
+}
+
+func Test_splitMessage(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{name: "fits", text: "one two", limit: 10, want: []string{"one two"}},
+		{name: "empty", text: " ", limit: 10, want: nil},
+		{name: "paragraphs", text: "one two\n\nthree\n\nfour five", limit: 14, want: []string{"one two\n\nthree", "four five"}},
+		{name: "lines", text: "one two\nthree four", limit: 10, want: []string{"one two", "three four"}},
+		{name: "words", text: "one two three", limit: 8, want: []string{"one two", "three"}},
+		{name: "long word", text: "abcdefghij", limit: 4, want: []string{"abcd", "efgh", "ij"}},
+		{name: "surrogate pairs", text: "😀😀😀", limit: 3, want: []string{"😀", "😀", "😀"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := splitMessage(tt.text, tt.limit)
+			assert.Equal(t, tt.want, got)
+			for _, part := range got {
+				assert.LessOrEqual(t, textLength(part), tt.limit)
+			}
+		})
+	}
+}
+
+func Test_splitAnswer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{name: "no code", text: "one two\nthree four", limit: 18, want: []string{"one two", "three four"}},
+		{name: "block kept", text: "one\n```\ntwo\n```", limit: 24, want: []string{"one\n```\ntwo\n```"}},
+		{name: "block cut", text: "```\none two\nthree four\n```", limit: 25, want: []string{"```\none two\n```", "```\nthree four\n```"}},
+		{name: "text after block", text: "```go\none\n```\n\ntwo three four five", limit: 27, want: []string{"```go\none\n```", "two three four five"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := splitAnswer(tt.text, tt.limit)
+			assert.Equal(t, tt.want, got)
+			for _, part := range got {
+				assert.LessOrEqual(t, textLength(part), tt.limit)
+			}
+		})
+	}
+}
+
+func TestOpenAI_Complete(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	messages := []ChatMessage{{Role: RoleSystem, Content: "You are a KYC assistant."}, {Role: RoleUser, Content: "What is KYC?"}}
+
+	tests := []struct {
+		name   string
+		llm    func(client IHTTPClient) *OpenAI
+		status int
+		body   string
+		url    string
+		auth   string
+		model  string
+		want   string
+		err    error
+	}{
+		{
+			name:   "openai",
+			llm:    func(client IHTTPClient) *OpenAI { return NewOpenAI(client, "", "", "sk-test") },
+			status: http.StatusOK,
+			body:   `{"choices":[{"message":{"role":"assistant","content":"KYC is the identity verification."}}]}`,
+			url:    "https://api.openai.com/v1/chat/completions",
+			auth:   "Bearer sk-test",
+			model:  DefaultLLMModel,
+			want:   "KYC is the identity verification.",
+		},
+		{
+			name: "self-hosted",
+			llm: func(client IHTTPClient) *OpenAI {
+				return NewOpenAI(client, "http://llm.local:8000/v1/", "llama-3-8b", "")
+			},
+			status: http.StatusOK,
+			body:   `{"choices":[{"message":{"role":"assistant","content":"Hello"}}]}`,
+			url:    "http://llm.local:8000/v1/chat/completions",
+			model:  "llama-3-8b",
+			want:   "Hello",
+		},
+		{
+			name:   "api error",
+			llm:    func(client IHTTPClient) *OpenAI { return NewOpenAI(client, "", "", "test") },
+			status: http.StatusUnauthorized,
+			body:   `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error"}}`,
+			url:    "https://api.openai.com/v1/chat/completions",
+			auth:   "Bearer test",
+			model:  DefaultLLMModel,
+			err:    errors.New("llm request failed with status 401: Incorrect API key provided"),
+		},
+		{
+			name:   "gateway error",
+			llm:    func(client IHTTPClient) *OpenAI { return NewOpenAI(client, "", "", "test") },
+			status: http.StatusBadGateway,
+			body:   `<html>Bad Gateway</html>`,
+			url:    "https://api.openai.com/v1/chat/completions",
+			auth:   "Bearer test",
+			model:  DefaultLLMModel,
+			err:    errors.New("llm request failed with status 502"),
+		},
+		{
+			name:   "no choices",
+			llm:    func(client IHTTPClient) *OpenAI { return NewOpenAI(client, "", "", "test") },
+			status: http.StatusOK,
+			body:   `{"choices":[]}`,
+			url:    "https://api.openai.com/v1/chat/completions",
+			auth:   "Bearer test",
+			model:  DefaultLLMModel,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			httpMock := mock_telegram_bot.NewMockIHTTPClient(ctrl)
+			httpMock.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+				assert.Equal(t, http.MethodPost, req.Method)
+				assert.Equal(t, tt.url, req.URL.String())
+				assert.Equal(t, tt.auth, req.Header.Get("Authorization"))
+
+				var body completionRequest
+				assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+				assert.Equal(t, completionRequest{Model: tt.model, Messages: messages}, body)
+
+				return &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))}, nil
+			})
+
+			got, err := tt.llm(httpMock).Complete(ctx, messages)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStubLLM_Complete(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	messages := []ChatMessage{{Role: RoleSystem, Content: "prompt"}, {Role: RoleUser, Content: "ping"}}
+
+	echo := &StubLLM{}
+	got, err := echo.Complete(ctx, messages)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", got)
+	assert.Equal(t, [][]ChatMessage{messages}, echo.Requests())
+
+	got, err = (&StubLLM{Reply: "pong"}).Complete(ctx, messages)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", got)
+}